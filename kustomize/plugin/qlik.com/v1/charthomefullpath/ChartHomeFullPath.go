@@ -1,10 +1,7 @@
 package main
 
 import (
-	"fmt"
-	"io/ioutil"
 	"log"
-	"os"
 	"path"
 
 	"github.com/qlik-oss/kustomize-plugins/kustomize/utils"
@@ -38,26 +35,9 @@ func (p *plugin) Config(ldr ifc.Loader, rf *resmap.Factory, c []byte) (err error
 	return yaml.Unmarshal(c, p)
 }
 
+// HelmChart renders from its own scoped copy of the chart home, so only the full path is needed here
 func (p *plugin) mutate(in interface{}) (interface{}, error) {
-	dir, err := ioutil.TempDir("", "temp")
-	if err != nil {
-		logger.Printf("error creating temporaty directory: %v\n", err)
-		return nil, err
-	}
-	directory := fmt.Sprintf("%s/%s", dir, p.ChartName)
-	err = os.Mkdir(directory, 0777)
-	if err != nil {
-		logger.Printf("error creating directory: %v, error: %v\n", directory, err)
-		return nil, err
-	}
-	if p.Kind == "HelmChart" {
-		err := utils.CopyDir(p.ChartHome, directory, logger)
-		if err != nil {
-			logger.Printf("error copying directory from: %v, to: %v, error: %v\n", p.ChartHome, directory, err)
-			return nil, err
-		}
-	}
-	return directory, nil
+	return p.ChartHome, nil
 }
 
 func (p *plugin) Transform(m resmap.ResMap) error {
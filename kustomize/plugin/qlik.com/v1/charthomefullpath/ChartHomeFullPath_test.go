@@ -53,7 +53,7 @@ releaseName: qliksense
 		require.NoError(t, err)
 	}
 
-	require.NotEqual(t, dir, chartHome)
+	require.Equal(t, dir, chartHome)
 
 	//open modified directory
 	directory, err := os.Open(chartHome)
@@ -61,7 +61,7 @@ releaseName: qliksense
 	objects, err := directory.Readdir(-1)
 	require.NoError(t, err)
 
-	//check the temp file was coppied over correctly
+	//check the chart home still holds the test file
 	for _, obj := range objects {
 		source := chartHome + "/" + obj.Name()
 		readFileContents, err := ioutil.ReadFile(source)
@@ -2,40 +2,217 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
-	"os/exec"
-	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/qlik-oss/kustomize-plugins/kustomize/utils"
+	"github.com/qlik-oss/kustomize-plugins/kustomize/utils/kustomizebuild"
 
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+	"helm.sh/helm/v3/pkg/provenance"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"helm.sh/helm/v3/pkg/repo"
+	"helm.sh/helm/v3/pkg/strvals"
+	"sigs.k8s.io/kustomize/v3/pkg/gvk"
 	"sigs.k8s.io/kustomize/v3/pkg/ifc"
 	"sigs.k8s.io/kustomize/v3/pkg/resmap"
 	"sigs.k8s.io/yaml"
 )
 
 type plugin struct {
-	ChartName        string                 `json:"chartName,omitempty" yaml:"chartName,omitempty"`
-	ChartHome        string                 `json:"chartHome,omitempty" yaml:"chartHome,omitempty"`
-	ChartVersion     string                 `json:"chartVersion,omitempty" yaml:"chartVersion,omitempty"`
-	ChartRepo        string                 `json:"chartRepo,omitempty" yaml:"chartRepo,omitempty"`
-	ValuesFrom       string                 `json:"valuesFrom,omitempty" yaml:"valuesFrom,omitempty"`
-	Values           map[string]interface{} `json:"values,omitempty" yaml:"values,omitempty"`
-	HelmHome         string                 `json:"helmHome,omitempty" yaml:"helmHome,omitempty"`
-	HelmBin          string                 `json:"helmBin,omitempty" yaml:"helmBin,omitempty"`
-	ReleaseName      string                 `json:"releaseName,omitempty" yaml:"releaseName,omitempty"`
-	ReleaseNamespace string                 `json:"releaseNamespace,omitempty" yaml:"releaseNamespace,omitempty"`
-	ExtraArgs        string                 `json:"extraArgs,omitempty" yaml:"extraArgs,omitempty"`
-	ChartPatches     string                 `json:"chartPatches,omitempty" yaml:"chartPatches,omitempty"`
-	SubChart         string       			`json:"subChart,omitempty" yaml:"subChart,omitempty"`
-	ChartVersionExp  string
-	ldr              ifc.Loader
-	rf               *resmap.Factory
+	ChartName             string                   `json:"chartName,omitempty" yaml:"chartName,omitempty"`
+	ChartHome             string                   `json:"chartHome,omitempty" yaml:"chartHome,omitempty"`
+	ChartVersion          string                   `json:"chartVersion,omitempty" yaml:"chartVersion,omitempty"`
+	ChartRepo             string                   `json:"chartRepo,omitempty" yaml:"chartRepo,omitempty"`
+	ValuesFrom            stringList               `json:"valuesFrom,omitempty" yaml:"valuesFrom,omitempty"`
+	Values                map[string]interface{}   `json:"values,omitempty" yaml:"values,omitempty"`
+	HelmHome              string                   `json:"helmHome,omitempty" yaml:"helmHome,omitempty"`
+	ReleaseName           string                   `json:"releaseName,omitempty" yaml:"releaseName,omitempty"`
+	ReleaseNamespace      string                   `json:"releaseNamespace,omitempty" yaml:"releaseNamespace,omitempty"`
+	EnforceNamespace      bool                     `json:"enforceNamespace,omitempty" yaml:"enforceNamespace,omitempty"`
+	ClusterScopedKinds    []gvk.Gvk                `json:"clusterScopedKinds,omitempty" yaml:"clusterScopedKinds,omitempty"`
+	ExtraArgs             extraArgs                `json:"extraArgs,omitempty" yaml:"extraArgs,omitempty"`
+	Set                   map[string]interface{}   `json:"set,omitempty" yaml:"set,omitempty"`
+	SetString             map[string]interface{}   `json:"setString,omitempty" yaml:"setString,omitempty"`
+	SetFile               map[string]string        `json:"setFile,omitempty" yaml:"setFile,omitempty"`
+	ChartPatches          string                   `json:"chartPatches,omitempty" yaml:"chartPatches,omitempty"`
+	SubChart              string                   `json:"subChart,omitempty" yaml:"subChart,omitempty"`
+	Offline               bool                     `json:"offline,omitempty" yaml:"offline,omitempty"`
+	DependencyMode        string                   `json:"dependencyMode,omitempty" yaml:"dependencyMode,omitempty"`
+	KubeVersion           string                   `json:"kubeVersion,omitempty" yaml:"kubeVersion,omitempty"`
+	APIVersions           []string                 `json:"apiVersions,omitempty" yaml:"apiVersions,omitempty"`
+	CapabilitiesFrom      string                   `json:"capabilitiesFrom,omitempty" yaml:"capabilitiesFrom,omitempty"`
+	Hooks                 string                   `json:"hooks,omitempty" yaml:"hooks,omitempty"`
+	IncludeCRDs           bool                     `json:"includeCRDs,omitempty" yaml:"includeCRDs,omitempty"`
+	CRDsOnly              bool                     `json:"crdsOnly,omitempty" yaml:"crdsOnly,omitempty"`
+	ValuesSchema          string                   `json:"valuesSchema,omitempty" yaml:"valuesSchema,omitempty"`
+	ChartDigest           string                   `json:"chartDigest,omitempty" yaml:"chartDigest,omitempty"`
+	Keyring               string                   `json:"keyring,omitempty" yaml:"keyring,omitempty"`
+	LockMode              string                   `json:"lockMode,omitempty" yaml:"lockMode,omitempty"`
+	Credentials           *credentials             `json:"credentials,omitempty" yaml:"credentials,omitempty"`
+	CAFile                string                   `json:"caFile,omitempty" yaml:"caFile,omitempty"`
+	CertFile              string                   `json:"certFile,omitempty" yaml:"certFile,omitempty"`
+	KeyFile               string                   `json:"keyFile,omitempty" yaml:"keyFile,omitempty"`
+	InsecureSkipTLSVerify bool                     `json:"insecureSkipTLSVerify,omitempty" yaml:"insecureSkipTLSVerify,omitempty"`
+	PassCredentialsAll    bool                     `json:"passCredentialsAll,omitempty" yaml:"passCredentialsAll,omitempty"`
+	ProvenanceStamp       *provenanceStamp         `json:"provenanceStamp,omitempty" yaml:"provenanceStamp,omitempty"`
+	Charts                []map[string]interface{} `json:"charts,omitempty" yaml:"charts,omitempty"`
+	ldr                   ifc.Loader
+	rf                    *resmap.Factory
+	workspace             string
+	chartDir              string
+	chartOrigin           string
+	renderedChart         *chart.Metadata
+	valuesHash            string
+	kindScopes            utils.KindScopes
+	charts                []*plugin
+	extraValues           extraArgValues
 }
 
+// extraArgs holds helm flags either as a list, one argv element per entry,
+// or as a single whitespace separated string. null, or the string "null", leaves it unset
+type extraArgs []string
+
+// extraArgValues are the values flags found in extraArgs, in the order given
+type extraArgValues struct {
+	set       []string
+	setString []string
+	setFile   []string
+	values    []string
+}
+
+func (e *extraArgs) UnmarshalJSON(data []byte) error {
+	var args []string
+	if err := json.Unmarshal(data, &args); err == nil {
+		*e = args
+		return nil
+	}
+	var argsString *string
+	if err := json.Unmarshal(data, &argsString); err != nil {
+		return fmt.Errorf("extraArgs must be a string or a list of strings, error: %v", err)
+	}
+	if argsString == nil || *argsString == "null" {
+		*e = nil
+	} else {
+		*e = strings.Fields(*argsString)
+	}
+	return nil
+}
+
+// secretRef names where a secret is read from, an environment variable or a file, secrets are never inline
+type secretRef struct {
+	Env  string `json:"env,omitempty" yaml:"env,omitempty"`
+	File string `json:"file,omitempty" yaml:"file,omitempty"`
+}
+
+// credentials for chartRepo, either a username and password or a bearer token
+type credentials struct {
+	Username *secretRef `json:"username,omitempty" yaml:"username,omitempty"`
+	Password *secretRef `json:"password,omitempty" yaml:"password,omitempty"`
+	Token    *secretRef `json:"token,omitempty" yaml:"token,omitempty"`
+}
+
+// provenanceStamp maps annotation and label names to the provenance field stamped into them on every rendered resource,
+// the fields are chart, chartVersion, appVersion, release, namespace and valuesHash.
+// With neither annotations nor labels the default annotations are stamped, enabled: false turns stamping off
+type provenanceStamp struct {
+	Enabled     *bool             `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+}
+
+var defaultProvenanceAnnotations = map[string]string{
+	"qlik.com/chart":         "chart",
+	"qlik.com/chart-version": "chartVersion",
+	"qlik.com/release":       "release",
+	"qlik.com/values-hash":   "valuesHash",
+}
+
+// stringList is a list of strings that may also be given as a single string. null, or the string "null", leaves it unset
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var list []string
+	if err := json.Unmarshal(data, &list); err == nil {
+		*l = list
+		return nil
+	}
+	var item *string
+	if err := json.Unmarshal(data, &item); err != nil {
+		return fmt.Errorf("expected a string or a list of strings, error: %v", err)
+	}
+	if item == nil || *item == "null" {
+		*l = nil
+	} else {
+		*l = []string{*item}
+	}
+	return nil
+}
+
+const (
+	hooksInclude      = "include"
+	hooksExclude      = "exclude"
+	hooksExcludeTests = "excludeTests"
+	hooksConvert      = "convert"
+
+	lockIgnore = "ignore"
+	lockWrite  = "write"
+	lockVerify = "verify"
+
+	hookAnnotation       = "qlik.com/helm-hook"
+	hookWeightAnnotation = "qlik.com/helm-hook-weight"
+	crdAnnotation        = "qlik.com/helm-crd"
+)
+
+var manifestSeparator = regexp.MustCompile(`(?m)^---[ \t]*$`)
+
+var invalidLabelValueRegexp = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// chartsDefaults are the top level fields that every charts entry inherits unless it sets them itself,
+// every other chart field belongs to a single chart and is only allowed in the entries
+var chartsDefaults = map[string]bool{
+	"chartRepo":             true,
+	"helmHome":              true,
+	"releaseNamespace":      true,
+	"enforceNamespace":      true,
+	"clusterScopedKinds":    true,
+	"offline":               true,
+	"dependencyMode":        true,
+	"kubeVersion":           true,
+	"apiVersions":           true,
+	"capabilitiesFrom":      true,
+	"hooks":                 true,
+	"includeCRDs":           true,
+	"crdsOnly":              true,
+	"keyring":               true,
+	"lockMode":              true,
+	"credentials":           true,
+	"caFile":                true,
+	"certFile":              true,
+	"keyFile":               true,
+	"insecureSkipTLSVerify": true,
+	"passCredentialsAll":    true,
+	"provenanceStamp":       true,
+}
+
+// provenanceFileRegexp finds the archive name in the files section of a provenance file
+var provenanceFileRegexp = regexp.MustCompile(`(?m)^\s+(\S+\.tgz):\s+sha256:[0-9a-f]+\s*$`)
+
 //nolint: go-lint noinspection GoUnusedGlobalVariable
 var KustomizePlugin plugin
 
@@ -48,43 +225,92 @@ func init() {
 func (p *plugin) Config(ldr ifc.Loader, rf *resmap.Factory, c []byte) (err error) {
 	p.ldr = ldr
 	p.rf = rf
-	return yaml.Unmarshal(c, p)
-}
-
-func (p *plugin) Generate() (resmap.ResMap, error) {
-
-	// make temp directory
-	dir, err := ioutil.TempDir("", "tempRoot")
-	if err != nil {
-		logger.Printf("error creating temporaty directory: %v\n", err)
-		return nil, err
+	if err := yaml.Unmarshal(c, p); err != nil {
+		return err
+	}
+	if err := p.parseExtraArgs(); err != nil {
+		logger.Printf("error parsing extraArgs, error: %v\n", err)
+		return err
+	}
+	if len(p.Charts) == 0 {
+		if err := (&utils.HTTPOptions{CertFile: p.CertFile, KeyFile: p.KeyFile}).Validate(); err != nil {
+			logger.Printf("error validating the tls settings, error: %v\n", err)
+			return err
+		}
+		return nil
 	}
-	dir = path.Join(dir, "../")
 
-	if p.HelmHome == "" {
-		// make home for helm stuff
-		directory := fmt.Sprintf("%s/%s", dir, "dotHelm")
-		p.HelmHome = directory
+	// every charts entry is a config of its own, the top level chartsDefaults being the defaults for the fields it leaves out
+	var topLevel map[string]interface{}
+	if err := yaml.Unmarshal(c, &topLevel); err != nil {
+		return err
+	}
+	defaults := make(map[string]interface{}, len(topLevel))
+	var chartFields []string
+	for key, value := range topLevel {
+		switch {
+		case key == "apiVersion" || key == "kind" || key == "metadata" || key == "charts":
+		case chartsDefaults[key]:
+			defaults[key] = value
+		default:
+			chartFields = append(chartFields, key)
+		}
+	}
+	if len(chartFields) > 0 {
+		sort.Strings(chartFields)
+		err := fmt.Errorf("%v belong to a single chart and cannot be set next to charts, set them in the charts entries", strings.Join(chartFields, ", "))
+		logger.Printf("%v\n", err)
+		return err
+	}
+	for i, entry := range p.Charts {
+		if _, ok := entry["charts"]; ok {
+			return fmt.Errorf("charts[%v] cannot hold charts of its own", i)
+		}
+		chartConfig := make(map[string]interface{}, len(defaults)+len(entry))
+		for key, value := range defaults {
+			chartConfig[key] = value
+		}
+		for key, value := range entry {
+			chartConfig[key] = value
+		}
+		chartBytes, err := yaml.Marshal(chartConfig)
+		if err != nil {
+			return err
+		}
+		chartPlugin := &plugin{}
+		if err := chartPlugin.Config(ldr, rf, chartBytes); err != nil {
+			return fmt.Errorf("error in charts[%v], error: %v", i, err)
+		}
+		p.charts = append(p.charts, chartPlugin)
 	}
+	return nil
+}
 
-	if len(p.ChartHome) == 0 {
-		// make home for chart stuff
-		directory := fmt.Sprintf("%s/%s", dir, p.ChartName)
-		p.ChartHome = directory
+func (p *plugin) Generate() (resmap.ResMap, error) {
+	if len(p.charts) > 0 {
+		return p.generateCharts()
 	}
 
-	if p.HelmBin == "" {
-		p.HelmBin = "helm"
+	if len(p.HelmHome) > 0 {
+		if err := os.MkdirAll(p.HelmHome, 0755); err != nil {
+			logger.Printf("error creating helm home: %v, error: %v\n", p.HelmHome, err)
+			return nil, err
+		}
 	}
 
-	if len(p.ChartVersion) > 0 {
-		p.ChartVersionExp = fmt.Sprintf("--version=%s", p.ChartVersion)
-	} else {
-		p.ChartVersionExp = ""
+	// every build gets its own workspace so that parallel builds never share chart copies,
+	// everything in it is removed once the chart is rendered
+	workspace, err := ioutil.TempDir(p.HelmHome, "helmchart-")
+	if err != nil {
+		logger.Printf("error creating workspace directory in: %v, error: %v\n", p.HelmHome, err)
+		return nil, err
 	}
+	defer os.RemoveAll(workspace)
+	p.workspace = workspace
+	p.chartDir = filepath.Join(workspace, p.ChartName)
 
 	if p.ChartRepo == "" {
-		p.ChartRepo = "https://kubernetes-charts.storage.googleapis.com"
+		p.ChartRepo = "https://charts.helm.sh/stable"
 	}
 
 	if p.ReleaseName == "" {
@@ -92,28 +318,44 @@ func (p *plugin) Generate() (resmap.ResMap, error) {
 	}
 
 	if p.ReleaseNamespace == "" {
-		p.ReleaseName = "default"
+		p.ReleaseNamespace = "default"
 	}
 
-	err = p.initHelm()
-	if err != nil {
-		logger.Printf("error executing initHelm(), error: %v\n", err)
+	chartHomeExists := false
+	if len(p.ChartHome) > 0 {
+		if _, err := os.Stat(p.ChartHome); err == nil {
+			chartHomeExists = true
+		} else if !os.IsNotExist(err) {
+			logger.Printf("error executing stat on file: %v, error: %v\n", p.ChartHome, err)
+			return nil, err
+		}
+	}
+
+	if err := p.checkLockMode(chartHomeExists); err != nil {
+		logger.Printf("error executing checkLockMode(), error: %v\n", err)
 		return nil, err
 	}
-	if _, err := os.Stat(p.ChartHome); os.IsNotExist(err) {
+
+	p.chartOrigin = p.chartDir
+	if chartHomeExists {
+		if len(p.ChartDigest) > 0 || len(p.Keyring) > 0 {
+			err := fmt.Errorf("chart: %v in chartHome: %v is not fetched and cannot be verified, chartDigest and keyring need an empty chartHome", p.ChartName, p.ChartHome)
+			logger.Printf("%v\n", err)
+			return nil, err
+		}
+		// work on a copy so that nothing below ever modifies the chart home
+		p.chartOrigin = p.ChartHome
+		err = utils.CopyDir(p.ChartHome, p.chartDir, logger)
+		if err != nil {
+			logger.Printf("error copying directory from: %v, to: %v, error: %v\n", p.ChartHome, p.chartDir, err)
+			return nil, err
+		}
+	} else {
 		err = p.fetchHelm()
 		if err != nil {
 			logger.Printf("error executing fetchHelm(), error: %v\n", err)
 			return nil, err
 		}
-	} else if err != nil {
-		logger.Printf("error executing stat on file: %v, error: %v\n", p.ChartHome, err)
-	}
-
-	err = deleteRequirements(p.ChartHome)
-	if err != nil {
-		logger.Printf("error executing deleteRequirements() for dir: %v, error: %v\n", p.ChartHome, err)
-		return nil, err
 	}
 
 	templatedYaml, err := p.templateHelm()
@@ -122,228 +364,1644 @@ func (p *plugin) Generate() (resmap.ResMap, error) {
 		return nil, err
 	}
 
+	var resMap resmap.ResMap
 	if len(p.ChartPatches) > 0 {
-		templatedYaml, err = p.applyPatches(templatedYaml)
+		resMap, err = p.applyPatches(templatedYaml)
 		if err != nil {
 			logger.Printf("error executing applyPatches(), error: %v\n", err)
 			return nil, err
 		}
+	} else {
+		resMap, err = p.rf.NewResMapFromBytes(templatedYaml)
+		if err != nil {
+			logger.Printf("error creating resmap from rendered chart, error: %v\n", err)
+			return nil, err
+		}
+	}
+
+	if p.EnforceNamespace {
+		p.enforceNamespace(resMap)
+	}
+
+	if err := p.stampProvenance(resMap); err != nil {
+		logger.Printf("error executing stampProvenance(), error: %v\n", err)
+		return nil, err
+	}
+	return resMap, nil
+}
+
+// generateCharts renders the charts entries concurrently, the output keeps the order of the entries
+func (p *plugin) generateCharts() (resmap.ResMap, error) {
+	resMaps := make([]resmap.ResMap, len(p.charts))
+	errs := make([]error, len(p.charts))
+	var wg sync.WaitGroup
+	for i, chartPlugin := range p.charts {
+		wg.Add(1)
+		go func(i int, chartPlugin *plugin) {
+			defer wg.Done()
+			resMaps[i], errs[i] = chartPlugin.Generate()
+		}(i, chartPlugin)
+	}
+	wg.Wait()
+
+	resMap := resmap.New()
+	for i, chartPlugin := range p.charts {
+		if errs[i] != nil {
+			err := fmt.Errorf("error rendering chart: %v (charts[%v]), error: %v", chartPlugin.ChartName, i, errs[i])
+			logger.Printf("%v\n", err)
+			return nil, err
+		}
+		if err := resMap.AppendAll(resMaps[i]); err != nil {
+			err = fmt.Errorf("error merging the output of chart: %v (charts[%v]), error: %v", chartPlugin.ChartName, i, err)
+			logger.Printf("%v\n", err)
+			return nil, err
+		}
 	}
+	return resMap, nil
+}
 
-	return p.rf.NewResMapFromBytes(templatedYaml)
+// enforceNamespace sets releaseNamespace on the namespaced resources rendered without a namespace.
+// Cluster-scoped kinds are those of the kubernetes api, those the CRDs of the chart or the output declare
+// cluster-scoped, and clusterScopedKinds
+func (p *plugin) enforceNamespace(resMap resmap.ResMap) {
+	scopes := p.kindScopes
+	if scopes == nil {
+		scopes = utils.NewKindScopes()
+	}
+	for _, res := range resMap.Resources() {
+		scopes.AddCRD(res.Map())
+	}
+	for _, kind := range p.ClusterScopedKinds {
+		scopes.Set(kind.Group, kind.Kind, true)
+	}
+	for _, res := range resMap.Resources() {
+		resGvk := res.GetGvk()
+		if len(res.GetNamespace()) > 0 || scopes.IsClusterScoped(resGvk.Group, resGvk.Kind) {
+			continue
+		}
+		res.SetNamespace(p.ReleaseNamespace)
+	}
 }
 
-func deleteRequirements(dir string) error {
+// stampProvenance sets the provenanceStamp annotations and labels on every resource in resMap
+func (p *plugin) stampProvenance(resMap resmap.ResMap) error {
+	if p.ProvenanceStamp == nil || (p.ProvenanceStamp.Enabled != nil && !*p.ProvenanceStamp.Enabled) {
+		return nil
+	}
+	annotations, labels := p.ProvenanceStamp.Annotations, p.ProvenanceStamp.Labels
+	if len(annotations) == 0 && len(labels) == 0 {
+		annotations = defaultProvenanceAnnotations
+	}
 
-	d, err := os.Open(dir)
+	fields := map[string]string{
+		"chart":        p.renderedChart.Name,
+		"chartVersion": p.renderedChart.Version,
+		"appVersion":   p.renderedChart.AppVersion,
+		"release":      p.ReleaseName,
+		"namespace":    p.ReleaseNamespace,
+		"valuesHash":   p.valuesHash,
+	}
+	stamp := func(names map[string]string, sanitize func(string) string) (map[string]string, error) {
+		stamped := make(map[string]string, len(names))
+		for name, field := range names {
+			value, ok := fields[field]
+			if !ok {
+				return nil, fmt.Errorf("unknown provenance field: %v for: %v, expected one of: chart, chartVersion, appVersion, release, namespace, valuesHash", field, name)
+			}
+			stamped[name] = sanitize(value)
+		}
+		return stamped, nil
+	}
+	stampedAnnotations, err := stamp(annotations, func(value string) string { return value })
 	if err != nil {
-		logger.Printf("error opening directory %v, error: %v\n", dir, err)
+		logger.Printf("%v\n", err)
 		return err
 	}
-	defer d.Close()
-
-	files, err := d.Readdir(-1)
+	stampedLabels, err := stamp(labels, labelValue)
 	if err != nil {
-		logger.Printf("error listing directory %v, error: %v\n", d.Name(), err)
+		logger.Printf("%v\n", err)
 		return err
 	}
 
-	for _, file := range files {
-		if file.Mode().IsRegular() {
-			ext := filepath.Ext(file.Name())
-			name := file.Name()[0 : len(file.Name())-len(ext)]
-			if name == "requirements" {
-				filePath := dir + "/" + file.Name()
-				err := os.Remove(filePath)
-				if err != nil {
-					logger.Printf("error deleting the requirements file %v, error: %v\n", filePath, err)
-					return err
-				}
+	for _, res := range resMap.Resources() {
+		if len(stampedAnnotations) > 0 {
+			resAnnotations := res.GetAnnotations()
+			if resAnnotations == nil {
+				resAnnotations = map[string]string{}
+			}
+			for name, value := range stampedAnnotations {
+				resAnnotations[name] = value
+			}
+			res.SetAnnotations(resAnnotations)
+		}
+		if len(stampedLabels) > 0 {
+			resLabels := res.GetLabels()
+			if resLabels == nil {
+				resLabels = map[string]string{}
+			}
+			for name, value := range stampedLabels {
+				resLabels[name] = value
 			}
+			res.SetLabels(resLabels)
 		}
 	}
-
 	return nil
 }
 
-func (p *plugin) initHelm() error {
-	// build helm flags
-	home := fmt.Sprintf("--home=%s", p.HelmHome)
-	helmCmd := exec.Command(p.HelmBin, "init", home, "--client-only")
-	err := helmCmd.Run()
-	if err != nil {
-		logger.Printf("error executing command: %v with args: %v, error: %v\n", helmCmd.Path, helmCmd.Args, err)
-		return err
+// labelValue makes value a valid label value: at most 63 alphanumerics, '-', '_' or '.',
+// starting and ending with an alphanumeric. Anything else, like the '+' of semver build metadata, becomes '_'
+func labelValue(value string) string {
+	value = invalidLabelValueRegexp.ReplaceAllString(value, "_")
+	if len(value) > 63 {
+		value = value[:63]
+	}
+	return strings.Trim(value, "-_.")
+}
+
+// checkLockMode fails when lockMode write or verify is set for a chart that is not fetched from a chart repo
+// or registry, a pre-populated chartHome, a git source or a local chart never goes through the lock file
+func (p *plugin) checkLockMode(chartHomeExists bool) error {
+	if len(p.LockMode) == 0 || p.LockMode == lockIgnore {
+		return nil
+	}
+	if chartHomeExists {
+		return fmt.Errorf("chart: %v in chartHome: %v is not fetched and cannot be locked, lockMode: %v needs an empty chartHome", p.ChartName, p.ChartHome, p.LockMode)
+	}
+	if _, local := p.localChartSource(); local || utils.IsGitSource(p.ChartRepo) {
+		return fmt.Errorf("chart: %v from: %v cannot be locked, lockMode: %v needs a chart repo or registry", p.ChartName, p.ChartRepo, p.LockMode)
 	}
 	return nil
 }
 
 func (p *plugin) fetchHelm() error {
+	verifyProvenance := len(p.Keyring) > 0
+	var archive, provenanceData []byte
+	if utils.IsGitSource(p.ChartRepo) {
+		if len(p.ChartDigest) > 0 || verifyProvenance {
+			err := fmt.Errorf("chart: %v from git source: %v cannot be verified, chartDigest and keyring need a chart archive", p.ChartName, p.ChartRepo)
+			logger.Printf("%v\n", err)
+			return err
+		}
+		return p.checkoutGitChart()
+	} else if localPath, ok := p.localChartSource(); ok {
+		info, err := os.Stat(localPath)
+		if err != nil {
+			logger.Printf("error executing stat on local chart source: %v, error: %v\n", localPath, err)
+			return err
+		}
+		if info.IsDir() {
+			if len(p.ChartDigest) > 0 || verifyProvenance {
+				err := fmt.Errorf("chart: %v from directory: %v cannot be verified, chartDigest and keyring need a chart archive", p.ChartName, localPath)
+				logger.Printf("%v\n", err)
+				return err
+			}
+			return p.copyLocalChart(localPath)
+		}
+		archive, err = ioutil.ReadFile(localPath)
+		if err != nil {
+			logger.Printf("error reading chart archive: %v, error: %v\n", localPath, err)
+			return err
+		}
+		if verifyProvenance {
+			provenanceData, err = ioutil.ReadFile(localPath + ".prov")
+			if err != nil {
+				logger.Printf("error reading provenance file: %v, error: %v\n", localPath+".prov", err)
+				return err
+			}
+		}
+	} else {
+		var err error
+		archive, provenanceData, err = p.loadLockedChartArchive(verifyProvenance)
+		if err != nil {
+			logger.Printf("error executing loadLockedChartArchive(), error: %v\n", err)
+			return err
+		}
+	}
 
-	// build helm flags
-	home := fmt.Sprintf("--home=%s", p.HelmHome)
-	untarDir := fmt.Sprintf("--untardir=%s", p.ChartHome)
-	repo := fmt.Sprintf("--repo=%s", p.ChartRepo)
-	helmCmd := exec.Command("helm", "fetch", home, "--untar", untarDir, repo, p.ChartVersionExp, p.ChartName)
+	if err := p.verifyChart(archive, provenanceData); err != nil {
+		logger.Printf("error executing verifyChart(), error: %v\n", err)
+		return err
+	}
 
-	var out bytes.Buffer
-	helmCmd.Stdout = &out
-	err := helmCmd.Run()
+	untarDir, err := ioutil.TempDir(p.workspace, "untar")
 	if err != nil {
-		logger.Printf("error executing command: %v with args: %v, error: %v\n", helmCmd.Path, helmCmd.Args, err)
+		logger.Printf("error creating temporary directory in: %v, error: %v\n", p.workspace, err)
 		return err
 	}
+	defer os.RemoveAll(untarDir)
 
-	fileLocation := fmt.Sprintf("%s/%s", p.ChartHome, p.ChartName)
-	tempFileLocation := fileLocation + "-temp"
-
-	logger.Printf(fileLocation)
-	err = os.Rename(fileLocation, tempFileLocation)
+	err = chartutil.Expand(untarDir, bytes.NewReader(archive))
 	if err != nil {
-		logger.Printf("error renaming: %v to: %v, error: %v\n", fileLocation, tempFileLocation, err)
+		logger.Printf("error expanding chart archive for chart: %v into: %v, error: %v\n", p.ChartName, untarDir, err)
 		return err
 	}
 
-	err = utils.CopyDir(tempFileLocation, p.ChartHome, logger)
-	if err != nil {
-		logger.Printf("error copying directory from: %v, to: %v, error: %v\n", tempFileLocation, p.ChartHome, err)
+	// the archive expands into a single directory named after the chart
+	files, err := ioutil.ReadDir(untarDir)
+	if err != nil || len(files) != 1 || !files[0].IsDir() {
+		err = fmt.Errorf("chart archive for chart: %v does not hold a single chart directory", p.ChartName)
+		logger.Printf("%v\n", err)
 		return err
 	}
-	logger.Printf(fileLocation)
-	err = os.RemoveAll(tempFileLocation)
+	fileLocation := filepath.Join(untarDir, files[0].Name())
+	err = os.Rename(fileLocation, p.chartDir)
 	if err != nil {
-		logger.Printf("error removing: %v, error: %v\n", tempFileLocation, err)
+		logger.Printf("error renaming: %v to: %v, error: %v\n", fileLocation, p.chartDir, err)
 		return err
 	}
 	return nil
-
 }
 
-func (p *plugin) templateHelm() ([]byte, error) {
+// verifyChart fails unless the archive has the sha256 digest in chartDigest, when set,
+// and unless its provenance file is signed by a key in keyring and holds the archive's digest, when keyring is set
+func (p *plugin) verifyChart(archive []byte, provenanceData []byte) error {
+	if len(p.ChartDigest) > 0 {
+		expected := strings.ToLower(strings.TrimPrefix(p.ChartDigest, "sha256:"))
+		if actual := utils.Digest(archive); actual != expected {
+			err := fmt.Errorf("chart: %v has digest: sha256:%v but chartDigest is: %v", p.ChartName, actual, p.ChartDigest)
+			logger.Printf("%v\n", err)
+			return err
+		}
+	}
+	if len(p.Keyring) == 0 {
+		return nil
+	}
+	return p.verifyProvenance(p.ChartName, archive, provenanceData)
+}
 
-	valuesYaml, err := yaml.Marshal(p.Values)
+// verifyProvenance fails unless the provenance file of the chart archive is signed by a key in keyring
+// and holds the archive's digest
+func (p *plugin) verifyProvenance(chartName string, archive []byte, provenanceData []byte) error {
+	// helm verifies files on disk, and the archive has to carry the name the provenance file signed
+	verifyDir, err := ioutil.TempDir(p.workspace, "verify")
 	if err != nil {
-		logger.Printf("error marshalling values to yaml, error: %v\n", err)
-		return nil, err
+		logger.Printf("error creating temporary directory in: %v, error: %v\n", p.workspace, err)
+		return err
 	}
-	file, err := ioutil.TempFile("", "yaml")
+	defer os.RemoveAll(verifyDir)
+
+	keyring := p.Keyring
+	keyringData, err := p.ldr.Load(keyring)
 	if err != nil {
-		logger.Printf("error creating temp file, error: %v\n", err)
-		return nil, err
+		logger.Printf("error loading keyring: %v, error: %v\n", keyring, err)
+		return err
+	}
+	keyringPath := filepath.Join(verifyDir, "keyring.gpg")
+	if err := ioutil.WriteFile(keyringPath, keyringData, 0644); err != nil {
+		logger.Printf("error writing file: %v, error: %v\n", keyringPath, err)
+		return err
 	}
-	_, err = file.Write(valuesYaml)
+	signatory, err := provenance.NewFromKeyring(keyringPath, "")
 	if err != nil {
-		logger.Printf("error writing yaml to file: %v, error: %v\n", file.Name(), err)
-		return nil, err
+		logger.Printf("error loading keyring: %v, error: %v\n", keyring, err)
+		return err
 	}
 
-	// build helm flags
-	home := fmt.Sprintf("--home=%s", p.HelmHome)
-	values := fmt.Sprintf("--values=%s", file.Name())
-	name := fmt.Sprintf("--name=%s", p.ReleaseName)
-	nameSpace := fmt.Sprintf("--namespace=%s", p.ReleaseNamespace)
-	chart := p.ChartHome
-	if len(p.SubChart) > 0 {
-		chart = p.ChartHome+ "/charts/" + p.SubChart
+	archiveName := chartName + ".tgz"
+	if match := provenanceFileRegexp.FindSubmatch(provenanceData); match != nil {
+		archiveName = filepath.Base(string(match[1]))
 	}
-	helmCmd := exec.Command("helm", "template", home, values, name, nameSpace, chart)
-
-	if len(p.ExtraArgs) > 0 && p.ExtraArgs != "null" {
-		helmCmd.Args = append(helmCmd.Args, p.ExtraArgs)
+	archivePath := filepath.Join(verifyDir, archiveName)
+	provenancePath := archivePath + ".prov"
+	if err := ioutil.WriteFile(archivePath, archive, 0644); err != nil {
+		logger.Printf("error writing file: %v, error: %v\n", archivePath, err)
+		return err
+	}
+	if err := ioutil.WriteFile(provenancePath, provenanceData, 0644); err != nil {
+		logger.Printf("error writing file: %v, error: %v\n", provenancePath, err)
+		return err
+	}
+	if _, err := signatory.Verify(archivePath, provenancePath); err != nil {
+		err = fmt.Errorf("provenance verification of chart: %v against keyring: %v failed, error: %v", chartName, keyring, err)
+		logger.Printf("%v\n", err)
+		return err
 	}
+	return nil
+}
 
-	if len(p.ValuesFrom) > 0 && p.ValuesFrom != "null" {
-		templatedValues := fmt.Sprintf("--values=%s", p.ValuesFrom)
-		helmCmd.Args = append(helmCmd.Args, templatedValues)
+// localChartSource returns the path chartRepo points to when it is a file:// url or a plain directory,
+// relative paths are relative to the kustomization root
+func (p *plugin) localChartSource() (string, bool) {
+	localPath := p.ChartRepo
+	if strings.HasPrefix(localPath, "file://") {
+		localPath = strings.TrimPrefix(localPath, "file://")
+	} else if strings.Contains(localPath, "://") {
+		return "", false
 	}
+	if !filepath.IsAbs(localPath) {
+		localPath = filepath.Join(p.ldr.Root(), localPath)
+	}
+	return localPath, true
+}
 
-	var out bytes.Buffer
-	var stderr bytes.Buffer
-	helmCmd.Stdout = &out
-	helmCmd.Stderr = &stderr
-	err = helmCmd.Run()
+// checkoutGitChart copies the chart from a git+ chartRepo, the directory its path points to
+// is either the chart itself or holds the chart in a sub directory named after it
+func (p *plugin) checkoutGitChart() error {
+	gitSource, err := utils.ParseGitSource(p.ChartRepo)
 	if err != nil {
-		logger.Printf("error executing command: %v with args: %v, error: %v, stderr: %v\n", helmCmd.Path, helmCmd.Args, err, stderr.String())
-		return nil, err
+		logger.Printf("error parsing git source: %v, error: %v\n", p.ChartRepo, err)
+		return err
 	}
-	return out.Bytes(), nil
+	localPath, err := gitSource.Checkout(filepath.Join(p.workspace, "git"), logger)
+	if err != nil {
+		logger.Printf("error checking out git source: %v, error: %v\n", p.ChartRepo, err)
+		return err
+	}
+	return p.copyLocalChart(localPath)
 }
 
-func (p *plugin) applyPatches(templatedHelm []byte) ([]byte, error) {
-	// get the patches
-	path := filepath.Join(p.ChartHome + "/" + p.ChartPatches + "/kustomization.yaml")
-	origYamlBytes, err := ioutil.ReadFile(path)
-	if err != nil {
-		logger.Printf("error reading file: %v, error: %v\n", path, err)
-		return nil, err
+// copyLocalChart copies the chart from a local directory, which is either the chart itself
+// or a directory holding the chart in a sub directory named after it
+func (p *plugin) copyLocalChart(localPath string) error {
+	chartPath := filepath.Join(localPath, p.ChartName)
+	if isChart, _ := chartutil.IsChartDir(chartPath); !isChart {
+		if isChart, _ := chartutil.IsChartDir(localPath); !isChart {
+			err := fmt.Errorf("neither: %v nor: %v is a chart directory", chartPath, localPath)
+			logger.Printf("%v\n", err)
+			return err
+		}
+		chartPath = localPath
 	}
 
-	var originalYamlMap map[string]interface{}
+	p.chartOrigin = chartPath
+	err := utils.CopyDir(chartPath, p.chartDir, logger)
+	if err != nil {
+		logger.Printf("error copying directory from: %v, to: %v, error: %v\n", chartPath, p.chartDir, err)
+		return err
+	}
+	return nil
+}
 
-	if err := yaml.Unmarshal(origYamlBytes, &originalYamlMap); err != nil {
-		logger.Printf("error unmarshalling kustomization yaml from file: %v, error: %v\n", path, err)
+// loadLockedChartArchive loads the chart archive from chartRepo according to lockMode:
+// ignore (the default) resolves chartVersion afresh, write resolves it and records the resolved version
+// and archive digest in the helmchart.lock next to the kustomization, verify loads the version pinned there
+// and fails unless the archive still has the pinned digest
+func (p *plugin) loadLockedChartArchive(withProvenance bool) ([]byte, []byte, error) {
+	lockPath := filepath.Join(p.ldr.Root(), utils.ChartLockFileName)
+	switch p.LockMode {
+	case "", lockIgnore:
+		return p.loadChartArchive(p.ChartRepo, p.ChartName, p.ChartVersion, withProvenance)
+	case lockWrite:
+		archive, provenanceData, err := p.loadChartArchive(p.ChartRepo, p.ChartName, p.ChartVersion, withProvenance)
+		if err != nil {
+			return nil, nil, err
+		}
+		helmChart, err := loader.LoadArchive(bytes.NewReader(archive))
+		if err != nil {
+			logger.Printf("error loading chart archive for chart: %v, error: %v\n", p.ChartName, err)
+			return nil, nil, err
+		}
+		err = utils.UpdateChartLock(lockPath, utils.LockedChart{
+			Repo:         p.ChartRepo,
+			Chart:        p.ChartName,
+			ChartVersion: p.ChartVersion,
+			Version:      helmChart.Metadata.Version,
+			Digest:       "sha256:" + utils.Digest(archive),
+		}, logger)
+		if err != nil {
+			logger.Printf("error updating chart lock file: %v, error: %v\n", lockPath, err)
+			return nil, nil, err
+		}
+		return archive, provenanceData, nil
+	case lockVerify:
+		lock, err := utils.ReadChartLock(lockPath, logger)
+		if err != nil {
+			return nil, nil, err
+		}
+		locked := lock.Find(p.ChartRepo, p.ChartName, p.ChartVersion)
+		if locked == nil {
+			err := fmt.Errorf("chart: %v, chartVersion: %v from repo: %v is not in the lock file: %v, build with lockMode: write to add it", p.ChartName, p.ChartVersion, p.ChartRepo, lockPath)
+			logger.Printf("%v\n", err)
+			return nil, nil, err
+		}
+		archive, provenanceData, err := p.loadChartArchive(p.ChartRepo, p.ChartName, locked.Version, withProvenance)
+		if err != nil {
+			return nil, nil, err
+		}
+		if digest := "sha256:" + utils.Digest(archive); digest != locked.Digest {
+			err := fmt.Errorf("chart: %v, version: %v from repo: %v has digest: %v but the lock file: %v pins: %v", p.ChartName, locked.Version, p.ChartRepo, digest, lockPath, locked.Digest)
+			logger.Printf("%v\n", err)
+			return nil, nil, err
+		}
+		return archive, provenanceData, nil
+	default:
+		err := fmt.Errorf("unknown lockMode: %v, expected one of: %v, %v, %v", p.LockMode, lockIgnore, lockWrite, lockVerify)
+		logger.Printf("%v\n", err)
+		return nil, nil, err
 	}
+}
 
-	// helmoutput file for kustomize build
-	helpOutputPath := p.ChartHome + "/" + p.ChartPatches + "/helmoutput.yaml"
-	f, err := os.Create(helpOutputPath)
+// loadChartArchive returns the chart archive from the chart cache, downloading it into the cache first on a miss.
+// withProvenance also returns the chart's provenance file, cached the same way.
+// In offline mode a cache miss is an error and the network is never used
+func (p *plugin) loadChartArchive(repoURL string, chartName string, version string, withProvenance bool) ([]byte, []byte, error) {
+	cache, err := utils.NewChartCache(logger)
 	if err != nil {
-		logger.Printf("error creating helm output file: %v, error: %v\n", helpOutputPath, err)
-		return nil, err
+		logger.Printf("error opening chart cache, error: %v\n", err)
+		return nil, nil, err
 	}
 
-	_, err = f.Write(templatedHelm)
+	unlock, err := cache.Lock(repoURL, chartName)
 	if err != nil {
-		logger.Printf("error writing to helm output file: %v, error: %v\n", helpOutputPath, err)
-		return nil, err
+		logger.Printf("error locking chart cache for chart: %v, error: %v\n", chartName, err)
+		return nil, nil, err
 	}
+	defer unlock()
 
-	kustomizeYaml, err := ioutil.ReadFile(path)
+	if p.Offline {
+		chartVersion, err := resolveCachedChartVersion(cache, repoURL, chartName, version)
+		if err != nil {
+			err = fmt.Errorf("offline mode: chart: %v, version: %v from repo: %v is not in the chart cache: %v, error: %v", chartName, version, repoURL, cache.Root(), err)
+			logger.Printf("%v\n", err)
+			return nil, nil, err
+		}
+		archive, err := cache.Get(repoURL, chartName, chartVersion.Version)
+		if err != nil {
+			err = fmt.Errorf("offline mode: unable to read chart: %v, version: %v from the chart cache: %v, error: %v", chartName, chartVersion.Version, cache.Root(), err)
+			logger.Printf("%v\n", err)
+			return nil, nil, err
+		}
+		if !withProvenance {
+			return archive, nil, nil
+		}
+		provenance, err := cache.GetProvenance(repoURL, chartName, chartVersion.Version)
+		if err != nil {
+			err = fmt.Errorf("offline mode: unable to read the provenance of chart: %v, version: %v from the chart cache: %v, error: %v", chartName, chartVersion.Version, cache.Root(), err)
+			logger.Printf("%v\n", err)
+			return nil, nil, err
+		}
+		return archive, provenance, nil
+	}
+
+	options, err := p.httpOptions(repoURL)
 	if err != nil {
-		logger.Printf("error reading file: %v, error: %v\n", path, err)
-		return nil, err
+		logger.Printf("error executing httpOptions() for repo: %v, error: %v\n", repoURL, err)
+		return nil, nil, err
 	}
 
-	var kustomizeYamlMap map[string]interface{}
-	if err := yaml.Unmarshal(kustomizeYaml, &kustomizeYamlMap); err != nil {
-		logger.Printf("error unmarshalling kustomization yaml from file: %v, error: %v\n", path, err)
+	remote, err := resolveRemoteChart(repoURL, chartName, version, options, p.PassCredentialsAll)
+	if err != nil {
+		logger.Printf("error resolving chart: %v, version: %v in repo: %v, error: %v\n", chartName, version, repoURL, err)
+		return nil, nil, err
 	}
 
-	delete(kustomizeYamlMap, "resources")
+	var archive []byte
+	if len(remote.digest) > 0 {
+		archive, err = cache.GetByDigest(remote.digest)
+		if err != nil && err != utils.ErrChartCacheMiss {
+			logger.Printf("error reading chart: %v, version: %v from the chart cache, error: %v\n", chartName, remote.version, err)
+		}
+		if err != nil {
+			archive = nil
+		}
+	}
 
-	kustomizeYamlMap["resources"] = []string{"helmoutput.yaml"}
+	if archive == nil {
+		archive, err = remote.download()
+		if err != nil {
+			logger.Printf("error downloading chart: %v, version: %v from repo: %v, error: %v\n", chartName, remote.version, repoURL, err)
+			return nil, nil, err
+		}
+		if len(remote.digest) > 0 && utils.Digest(archive) != remote.digest {
+			err := fmt.Errorf("chart: %v, version: %v from repo: %v has digest: %v but the repo expects: %v", chartName, remote.version, repoURL, utils.Digest(archive), remote.digest)
+			logger.Printf("%v\n", err)
+			return nil, nil, err
+		}
+	}
 
-	yamlM, err := yaml.Marshal(kustomizeYamlMap)
-	if err != nil {
-		logger.Printf("error marshalling kustomization yaml map, error: %v\n", err)
-		return nil, err
+	if _, err := cache.Put(repoURL, chartName, remote.version, archive); err != nil {
+		logger.Printf("error adding chart: %v, version: %v to the chart cache, error: %v\n", chartName, remote.version, err)
+		return nil, nil, err
+	}
+	if !withProvenance {
+		return archive, nil, nil
 	}
 
-	if err := ioutil.WriteFile(path, yamlM, 0644); err != nil {
-		logger.Printf("error writing kustomization yaml to file: %v, error: %v\n", path, err)
+	provenance, err := cache.GetProvenance(repoURL, chartName, remote.version)
+	if err == utils.ErrChartCacheMiss {
+		if remote.provenance == nil {
+			err := fmt.Errorf("chart: %v from repo: %v has no provenance file to verify", chartName, repoURL)
+			logger.Printf("%v\n", err)
+			return nil, nil, err
+		}
+		provenance, err = remote.provenance()
+		if err != nil {
+			logger.Printf("error downloading the provenance of chart: %v, version: %v from repo: %v, error: %v\n", chartName, remote.version, repoURL, err)
+			return nil, nil, err
+		}
+		if err := cache.PutProvenance(repoURL, chartName, remote.version, provenance); err != nil {
+			logger.Printf("error adding the provenance of chart: %v, version: %v to the chart cache, error: %v\n", chartName, remote.version, err)
+			return nil, nil, err
+		}
+	} else if err != nil {
+		logger.Printf("error reading the provenance of chart: %v, version: %v from the chart cache, error: %v\n", chartName, remote.version, err)
+		return nil, nil, err
 	}
+	return archive, provenance, nil
+}
 
-	// kustomize build
-	templatedHelm, err = p.buildPatches()
-	if err != nil {
-		logger.Printf("error executing buildPatches(), error: %v\n", err)
+// httpOptions returns the credentials and tls settings for repoURL. They belong to chartRepo,
+// so dependencies hosted anywhere else are fetched without them. The tls and credential files are provided
+// by whoever runs the build, e.g. under /etc/ssl or /run/secrets, so they are read from disk and not through
+// the loader, which would keep them inside the kustomization
+func (p *plugin) httpOptions(repoURL string) (*utils.HTTPOptions, error) {
+	if repoHost(repoURL) != repoHost(p.ChartRepo) {
+		return nil, nil
+	}
+	options := &utils.HTTPOptions{
+		CAFile:                p.relativeToRoot(p.CAFile),
+		CertFile:              p.relativeToRoot(p.CertFile),
+		KeyFile:               p.relativeToRoot(p.KeyFile),
+		InsecureSkipTLSVerify: p.InsecureSkipTLSVerify,
+	}
+	if p.Credentials == nil {
+		return options, nil
+	}
+	var err error
+	if options.Username, err = p.readSecret("username", p.Credentials.Username); err != nil {
+		return nil, err
+	}
+	if options.Password, err = p.readSecret("password", p.Credentials.Password); err != nil {
+		return nil, err
+	}
+	if options.Token, err = p.readSecret("token", p.Credentials.Token); err != nil {
 		return nil, err
 	}
+	return options, nil
+}
 
-	return templatedHelm, nil
+func (p *plugin) readSecret(name string, ref *secretRef) (string, error) {
+	if ref == nil {
+		return "", nil
+	}
+	switch {
+	case len(ref.Env) > 0 && len(ref.File) > 0:
+		return "", fmt.Errorf("credentials %v must come from either env or file, not both", name)
+	case len(ref.Env) > 0:
+		secret, ok := os.LookupEnv(ref.Env)
+		if !ok {
+			return "", fmt.Errorf("credentials %v: environment variable: %v is not set", name, ref.Env)
+		}
+		return secret, nil
+	case len(ref.File) > 0:
+		secret, err := ioutil.ReadFile(p.relativeToRoot(ref.File))
+		if err != nil {
+			return "", fmt.Errorf("credentials %v: unable to read file: %v, error: %v", name, ref.File, err)
+		}
+		return strings.TrimSpace(string(secret)), nil
+	default:
+		return "", fmt.Errorf("credentials %v must come from env or file", name)
+	}
 }
 
-func (p *plugin) buildPatches() ([]byte, error) {
-	path := filepath.Join(p.ChartHome + "/" + p.ChartPatches)
-	kustomizeCmd := exec.Command("kustomize", "build", path)
+// relativeToRoot resolves a local file path against the kustomization root
+func (p *plugin) relativeToRoot(filePath string) string {
+	if len(filePath) == 0 || filepath.IsAbs(filePath) {
+		return filePath
+	}
+	return filepath.Join(p.ldr.Root(), filePath)
+}
 
-	var out bytes.Buffer
-	kustomizeCmd.Stdout = &out
+func repoHost(repoURL string) string {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// sameOrigin is true when both urls have the same scheme and host, port included
+func sameOrigin(url1 string, url2 string) bool {
+	u1, err := url.Parse(url1)
+	if err != nil {
+		return false
+	}
+	u2, err := url.Parse(url2)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u1.Scheme, u2.Scheme) && strings.EqualFold(u1.Host, u2.Host)
+}
+
+// remoteChart is a chart version resolved in a chart repo or an OCI registry,
+// provenance is nil when the source has no provenance files
+type remoteChart struct {
+	version    string
+	digest     string
+	download   func() ([]byte, error)
+	provenance func() ([]byte, error)
+}
+
+func resolveRemoteChart(repoURL string, chartName string, version string, options *utils.HTTPOptions, passCredentialsAll bool) (*remoteChart, error) {
+	if strings.HasPrefix(repoURL, "oci://") {
+		return resolveOCIChart(repoURL, chartName, version, options)
+	}
+	return resolveRepoChart(repoURL, chartName, version, options, passCredentialsAll)
+}
+
+// resolveRepoChart resolves version in the repo index. The index may point the chart anywhere, so the repo's
+// credentials and tls settings only go along to a chart url with the repo's scheme and host, unless passCredentialsAll
+func resolveRepoChart(repoURL string, chartName string, version string, options *utils.HTTPOptions, passCredentialsAll bool) (*remoteChart, error) {
+	chartVersion, err := resolveChartVersion(repoURL, chartName, version, options)
+	if err != nil {
+		return nil, err
+	}
+	if len(chartVersion.URLs) == 0 {
+		return nil, fmt.Errorf("chart: %v, version: %v in repo: %v has no downloadable urls", chartName, chartVersion.Version, repoURL)
+	}
+	chartURL, err := repo.ResolveReferenceURL(repoURL, chartVersion.URLs[0])
+	if err != nil {
+		logger.Printf("error resolving chart url: %v against repo: %v, error: %v\n", chartVersion.URLs[0], repoURL, err)
+		return nil, err
+	}
+	chartOptions := options
+	if !passCredentialsAll && !sameOrigin(chartURL, repoURL) {
+		logger.Printf("chart url: %v is not on repo: %v, fetching it without the repo's credentials and tls settings\n", chartURL, repoURL)
+		chartOptions = nil
+	}
+	return &remoteChart{
+		version: chartVersion.Version,
+		digest:  chartVersion.Digest,
+		download: func() ([]byte, error) {
+			return utils.HTTPGetWithOptions(chartURL, chartOptions, logger)
+		},
+		provenance: func() ([]byte, error) {
+			return utils.HTTPGetWithOptions(chartURL+".prov", chartOptions, logger)
+		},
+	}, nil
+}
+
+// resolveOCIChart resolves version against the tags of <repoURL>/<chartName>,
+// helm pushes versions with build metadata using '_' in place of '+' since tags cannot hold a '+'
+func resolveOCIChart(repoURL string, chartName string, version string, options *utils.HTTPOptions) (*remoteChart, error) {
+	client, err := utils.NewOCIClientWithOptions(options, logger)
+	if err != nil {
+		return nil, err
+	}
+	ref := strings.TrimSuffix(repoURL, "/") + "/" + chartName
+
+	resolvedVersion := version
+	tags, err := client.Tags(ref)
+	if err == nil {
+		versions := make([]string, 0, len(tags))
+		for _, tag := range tags {
+			versions = append(versions, strings.Replace(tag, "_", "+", -1))
+		}
+		index := versionIndex(chartName, versions)
+		chartVersion, err := index.Get(chartName, version)
+		if err != nil {
+			return nil, err
+		}
+		resolvedVersion = chartVersion.Version
+	} else if len(version) == 0 {
+		return nil, err
+	} else {
+		logger.Printf("unable to list tags of: %v, using version: %v as the tag, error: %v\n", ref, version, err)
+	}
+
+	digest, err := client.ChartDigest(ref, strings.Replace(resolvedVersion, "+", "_", -1))
+	if err != nil {
+		return nil, err
+	}
+	return &remoteChart{
+		version: resolvedVersion,
+		digest:  digest,
+		download: func() ([]byte, error) {
+			return client.PullChart(ref, digest)
+		},
+	}, nil
+}
+
+func resolveChartVersion(repoURL string, chartName string, version string, options *utils.HTTPOptions) (*repo.ChartVersion, error) {
+	indexURL := strings.TrimSuffix(repoURL, "/") + "/index.yaml"
+	indexBytes, err := utils.HTTPGetWithOptions(indexURL, options, logger)
+	if err != nil {
+		logger.Printf("error downloading repo index: %v, error: %v\n", indexURL, err)
+		return nil, err
+	}
+
+	var index repo.IndexFile
+	if err := yaml.Unmarshal(indexBytes, &index); err != nil {
+		logger.Printf("error unmarshalling repo index: %v, error: %v\n", indexURL, err)
+		return nil, err
+	}
+	index.SortEntries()
+	return index.Get(chartName, version)
+}
+
+// resolveCachedChartVersion resolves version against the versions already in the chart cache,
+// using the same rules a repo index would
+func resolveCachedChartVersion(cache *utils.ChartCache, repoURL string, chartName string, version string) (*repo.ChartVersion, error) {
+	versions, err := cache.Versions(repoURL, chartName)
+	if err != nil {
+		logger.Printf("error listing cached versions of chart: %v, error: %v\n", chartName, err)
+		return nil, err
+	}
+	index := versionIndex(chartName, versions)
+	return index.Get(chartName, version)
+}
+
+// versionIndex builds a repo index of bare versions so that version ranges resolve the way they do against a chart repo
+func versionIndex(chartName string, versions []string) repo.IndexFile {
+	index := repo.IndexFile{Entries: map[string]repo.ChartVersions{}}
+	for _, version := range versions {
+		index.Entries[chartName] = append(index.Entries[chartName], &repo.ChartVersion{
+			Metadata: &chart.Metadata{Name: chartName, Version: version},
+		})
+	}
+	index.SortEntries()
+	return index
+}
+
+// prepareDependencies makes the chart's declared dependencies available according to dependencyMode:
+// vendored (the default) expects every dependency under charts/, resolve fetches the ones that are not,
+// strip drops the declarations so every vendored subchart renders unconditionally
+func (p *plugin) prepareDependencies(helmChart *chart.Chart, chartPath string) error {
+	switch p.DependencyMode {
+	case "strip":
+		helmChart.Metadata.Dependencies = nil
+		helmChart.Lock = nil
+		return nil
+	case "", "vendored":
+		if err := verifyLock(helmChart); err != nil {
+			return err
+		}
+		var missing []string
+		for _, dependency := range helmChart.Metadata.Dependencies {
+			if vendoredDependency(helmChart, dependency.Name) == nil {
+				missing = append(missing, dependency.Name)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("chart: %v declares dependencies that are not vendored under charts/: %v, "+
+				"use dependencyMode: resolve to fetch them or dependencyMode: strip to render without them", helmChart.Name(), strings.Join(missing, ", "))
+		}
+		return nil
+	case "resolve":
+		return p.resolveDependencies(helmChart, chartPath)
+	}
+	return fmt.Errorf("unknown dependencyMode: %v, expected one of: vendored, resolve, strip", p.DependencyMode)
+}
+
+// resolveDependencies fetches every declared dependency that is not vendored, from its repository
+// (the chart's own repo when none is given) through the chart cache, or from disk for file:// repositories.
+// Locked versions win over the declared version ranges. With a keyring every fetched dependency has to pass
+// provenance verification too, chartDigest only pins the chart's own archive so it cannot cover fetched dependencies
+func (p *plugin) resolveDependencies(helmChart *chart.Chart, chartPath string) error {
+	if err := verifyLock(helmChart); err != nil {
+		return err
+	}
+
+	for _, dependency := range helmChart.Metadata.Dependencies {
+		if vendoredDependency(helmChart, dependency.Name) != nil {
+			continue
+		}
+
+		version := dependency.Version
+		if lockedDependency := lockedDependency(helmChart, dependency.Name); lockedDependency != nil {
+			version = lockedDependency.Version
+		}
+
+		var dependencyChart *chart.Chart
+		var err error
+		switch {
+		case strings.HasPrefix(dependency.Repository, "file://"):
+			if len(p.Keyring) > 0 {
+				err := fmt.Errorf("dependency: %v of chart: %v from: %v cannot be verified, keyring needs a chart archive, vendor it under charts/ instead", dependency.Name, helmChart.Name(), dependency.Repository)
+				logger.Printf("%v\n", err)
+				return err
+			}
+			dependencyPath := strings.TrimPrefix(dependency.Repository, "file://")
+			if !filepath.IsAbs(dependencyPath) {
+				// relative to where the chart really lives, not to the workspace copy of it
+				dependencyPath = filepath.Join(p.chartOrigin+strings.TrimPrefix(chartPath, p.chartDir), dependencyPath)
+			}
+			dependencyChart, err = loader.Load(dependencyPath)
+			if err != nil {
+				logger.Printf("error loading dependency: %v from: %v, error: %v\n", dependency.Name, dependencyPath, err)
+				return err
+			}
+		case strings.HasPrefix(dependency.Repository, "@") || strings.HasPrefix(dependency.Repository, "alias:"):
+			return fmt.Errorf("dependency: %v of chart: %v refers to a named helm repo: %v, only repo urls are supported", dependency.Name, helmChart.Name(), dependency.Repository)
+		default:
+			repoURL := dependency.Repository
+			if repoURL == "" {
+				repoURL = p.ChartRepo
+			}
+			verifyProvenance := len(p.Keyring) > 0
+			if len(p.ChartDigest) > 0 && !verifyProvenance {
+				err := fmt.Errorf("dependency: %v of chart: %v cannot be verified, chartDigest only pins the chart archive, set keyring or vendor it under charts/", dependency.Name, helmChart.Name())
+				logger.Printf("%v\n", err)
+				return err
+			}
+			archive, provenanceData, err := p.loadChartArchive(repoURL, dependency.Name, version, verifyProvenance)
+			if err != nil {
+				logger.Printf("error fetching dependency: %v, version: %v from repo: %v, error: %v\n", dependency.Name, version, repoURL, err)
+				return err
+			}
+			if verifyProvenance {
+				if err := p.verifyProvenance(dependency.Name, archive, provenanceData); err != nil {
+					logger.Printf("error verifying dependency: %v, version: %v from repo: %v, error: %v\n", dependency.Name, version, repoURL, err)
+					return err
+				}
+			}
+			dependencyChart, err = loader.LoadArchive(bytes.NewReader(archive))
+			if err != nil {
+				logger.Printf("error loading dependency: %v archive, error: %v\n", dependency.Name, err)
+				return err
+			}
+		}
+		helmChart.AddDependency(dependencyChart)
+	}
+	return nil
+}
+
+// verifyLock checks that the chart's lock file (requirements.lock or Chart.lock), when it has one,
+// covers exactly the declared dependencies with versions inside their declared ranges, and that vendored
+// subcharts are the locked versions
+func verifyLock(helmChart *chart.Chart) error {
+	if helmChart.Lock == nil {
+		return nil
+	}
+	if len(helmChart.Lock.Dependencies) != len(helmChart.Metadata.Dependencies) {
+		return fmt.Errorf("lock file of chart: %v is out of date, it locks %v dependencies but %v are declared",
+			helmChart.Name(), len(helmChart.Lock.Dependencies), len(helmChart.Metadata.Dependencies))
+	}
+	for _, dependency := range helmChart.Metadata.Dependencies {
+		locked := lockedDependency(helmChart, dependency.Name)
+		if locked == nil {
+			return fmt.Errorf("lock file of chart: %v is out of date, dependency: %v is not locked", helmChart.Name(), dependency.Name)
+		}
+		if strings.TrimSuffix(locked.Repository, "/") != strings.TrimSuffix(dependency.Repository, "/") {
+			return fmt.Errorf("lock file of chart: %v is out of date, dependency: %v is locked to repo: %v but declared with repo: %v",
+				helmChart.Name(), dependency.Name, locked.Repository, dependency.Repository)
+		}
+		if len(dependency.Version) > 0 {
+			constraint, err := semver.NewConstraint(dependency.Version)
+			if err != nil {
+				return fmt.Errorf("dependency: %v of chart: %v has an invalid version: %v, error: %v", dependency.Name, helmChart.Name(), dependency.Version, err)
+			}
+			lockedVersion, err := semver.NewVersion(locked.Version)
+			if err != nil {
+				return fmt.Errorf("lock file of chart: %v has an invalid version: %v for dependency: %v, error: %v", helmChart.Name(), locked.Version, dependency.Name, err)
+			}
+			if !constraint.Check(lockedVersion) {
+				return fmt.Errorf("lock file of chart: %v is out of date, dependency: %v is locked to version: %v which does not satisfy: %v",
+					helmChart.Name(), dependency.Name, locked.Version, dependency.Version)
+			}
+		}
+		if vendored := vendoredDependency(helmChart, dependency.Name); vendored != nil && vendored.Metadata.Version != locked.Version {
+			return fmt.Errorf("vendored dependency: %v of chart: %v is version: %v but the lock file requires: %v",
+				dependency.Name, helmChart.Name(), vendored.Metadata.Version, locked.Version)
+		}
+	}
+	return nil
+}
+
+func vendoredDependency(helmChart *chart.Chart, name string) *chart.Chart {
+	for _, dependency := range helmChart.Dependencies() {
+		if dependency.Name() == name {
+			return dependency
+		}
+	}
+	return nil
+}
+
+func lockedDependency(helmChart *chart.Chart, name string) *chart.Dependency {
+	if helmChart.Lock == nil {
+		return nil
+	}
+	for _, dependency := range helmChart.Lock.Dependencies {
+		if dependency.Name == name {
+			return dependency
+		}
+	}
+	return nil
+}
+
+func (p *plugin) templateHelm() ([]byte, error) {
+	chartPath := p.chartDir
+	if len(p.SubChart) > 0 {
+		chartPath = p.chartDir + "/charts/" + p.SubChart
+	}
+	helmChart, err := loader.Load(chartPath)
+	if err != nil {
+		logger.Printf("error loading chart from: %v, error: %v\n", chartPath, err)
+		return nil, err
+	}
+
+	if err := p.prepareDependencies(helmChart, chartPath); err != nil {
+		logger.Printf("error executing prepareDependencies() for chart: %v, error: %v\n", chartPath, err)
+		return nil, err
+	}
+
+	values, sources, err := p.mergeValues()
+	if err != nil {
+		logger.Printf("error executing mergeValues(), error: %v\n", err)
+		return nil, err
+	}
+	valuesJSON, err := json.Marshal(values)
+	if err != nil {
+		logger.Printf("error marshalling values for chart: %v, error: %v\n", chartPath, err)
+		return nil, err
+	}
+	p.renderedChart = helmChart.Metadata
+	p.valuesHash = utils.Digest(valuesJSON)
+
+	if err := chartutil.ProcessDependencies(helmChart, values); err != nil {
+		logger.Printf("error processing dependencies for chart: %v, error: %v\n", chartPath, err)
+		return nil, err
+	}
+
+	if err := p.validateValues(helmChart, values, sources); err != nil {
+		logger.Printf("error validating values for chart: %v, error: %v\n", chartPath, err)
+		return nil, err
+	}
+
+	options := chartutil.ReleaseOptions{
+		Name:      p.ReleaseName,
+		Namespace: p.ReleaseNamespace,
+		Revision:  1,
+		IsInstall: true,
+	}
+	caps, err := p.capabilities()
+	if err != nil {
+		logger.Printf("error executing capabilities(), error: %v\n", err)
+		return nil, err
+	}
+	renderValues, err := chartutil.ToRenderValues(helmChart, values, options, caps)
+	if err != nil {
+		logger.Printf("error building render values for chart: %v, error: %v\n", chartPath, err)
+		return nil, err
+	}
+
+	files, err := engine.Render(helmChart, renderValues)
+	if err != nil {
+		logger.Printf("error rendering chart: %v, error: %v\n", chartPath, err)
+		return nil, err
+	}
 
-	err := kustomizeCmd.Run()
+	for name := range files {
+		if strings.HasSuffix(name, "NOTES.txt") {
+			delete(files, name)
+		}
+	}
+	hooks, manifests, err := releaseutil.SortManifests(files, caps.APIVersions, releaseutil.InstallOrder)
 	if err != nil {
-		logger.Printf("error executing command: %v with args: %v, error: %v\n", kustomizeCmd.Path, kustomizeCmd.Args, err)
+		logger.Printf("error sorting manifests for chart: %v, error: %v\n", chartPath, err)
 		return nil, err
 	}
+
+	converted, hooks, err := p.applyHooksPolicy(hooks)
+	if err != nil {
+		logger.Printf("error executing applyHooksPolicy() for chart: %v, error: %v\n", chartPath, err)
+		return nil, err
+	}
+
+	if p.EnforceNamespace {
+		// the chart's CRDs declare the scope of its custom resources whether or not they are rendered
+		p.kindScopes = utils.NewKindScopes()
+		for _, crdFile := range helmChart.CRDs() {
+			if ext := filepath.Ext(crdFile.Name); ext != ".yaml" && ext != ".yml" && ext != ".json" {
+				continue
+			}
+			if err := p.kindScopes.AddCRDs(crdFile.Data); err != nil {
+				logger.Printf("error reading crd file: %v of chart: %v, error: %v\n", crdFile.Name, chartPath, err)
+				return nil, err
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	if p.IncludeCRDs || p.CRDsOnly {
+		if err := writeCRDs(&out, helmChart); err != nil {
+			logger.Printf("error writing crds of chart: %v, error: %v\n", chartPath, err)
+			return nil, err
+		}
+		if p.CRDsOnly {
+			return out.Bytes(), nil
+		}
+	}
+	for _, hook := range converted {
+		fmt.Fprintf(&out, "---\n# Source: %s\n%s\n", hook.Path, hook.Manifest)
+	}
+	for _, manifest := range manifests {
+		fmt.Fprintf(&out, "---\n# Source: %s\n%s\n", manifest.Name, manifest.Content)
+	}
+	for _, hook := range hooks {
+		fmt.Fprintf(&out, "---\n# Source: %s\n%s\n", hook.Path, hook.Manifest)
+	}
 	return out.Bytes(), nil
 }
+
+// writeCRDs writes the crds/ files of the chart and its enabled dependencies,
+// every CRD is annotated so that it can be told apart and applied ahead of the resources using it
+func writeCRDs(out *bytes.Buffer, helmChart *chart.Chart) error {
+	for _, crdFile := range helmChart.CRDs() {
+		switch filepath.Ext(crdFile.Name) {
+		case ".yaml", ".yml", ".json":
+		default:
+			continue
+		}
+		for _, document := range manifestSeparator.Split(string(crdFile.Data), -1) {
+			var crd map[string]interface{}
+			if err := yaml.Unmarshal([]byte(document), &crd); err != nil {
+				logger.Printf("error unmarshalling crd file: %v, error: %v\n", crdFile.Name, err)
+				return err
+			}
+			if len(crd) == 0 {
+				continue
+			}
+			metadata, _ := crd["metadata"].(map[string]interface{})
+			if metadata == nil {
+				metadata = map[string]interface{}{}
+			}
+			annotations, _ := metadata["annotations"].(map[string]interface{})
+			if annotations == nil {
+				annotations = map[string]interface{}{}
+			}
+			annotations[crdAnnotation] = "true"
+			metadata["annotations"] = annotations
+			crd["metadata"] = metadata
+
+			data, err := yaml.Marshal(crd)
+			if err != nil {
+				logger.Printf("error marshalling crd from file: %v, error: %v\n", crdFile.Name, err)
+				return err
+			}
+			fmt.Fprintf(out, "---\n# Source: %s\n%s\n", crdFile.Name, data)
+		}
+	}
+	return nil
+}
+
+// applyHooksPolicy filters the chart's hooks according to hooks: include (the default) keeps every hook as is,
+// exclude drops every hook and excludeTests drops the test hooks. convert turns pre-install and pre-upgrade hooks
+// into ordinary resources annotated with their hook and weight, to be rendered ahead of the chart's manifests
+// in weight order, and drops every other hook. Converted hooks are returned separately from the hooks kept as hooks
+func (p *plugin) applyHooksPolicy(hooks []*release.Hook) ([]*release.Hook, []*release.Hook, error) {
+	switch p.Hooks {
+	case "", hooksInclude:
+		return nil, hooks, nil
+	case hooksExclude:
+		return nil, nil, nil
+	case hooksExcludeTests:
+		var kept []*release.Hook
+		for _, hook := range hooks {
+			if !hasHookEvent(hook, release.HookTest) {
+				kept = append(kept, hook)
+			}
+		}
+		return nil, kept, nil
+	case hooksConvert:
+		var converted []*release.Hook
+		for _, hook := range hooks {
+			if !hasHookEvent(hook, release.HookPreInstall) && !hasHookEvent(hook, release.HookPreUpgrade) {
+				continue
+			}
+			manifest, err := convertHook(hook)
+			if err != nil {
+				logger.Printf("error converting hook: %v, error: %v\n", hook.Path, err)
+				return nil, nil, err
+			}
+			converted = append(converted, &release.Hook{Path: hook.Path, Manifest: manifest, Weight: hook.Weight})
+		}
+		sort.SliceStable(converted, func(i, j int) bool {
+			return converted[i].Weight < converted[j].Weight
+		})
+		return converted, nil, nil
+	default:
+		err := fmt.Errorf("unknown hooks policy: %v, expected one of: %v, %v, %v, %v", p.Hooks, hooksInclude, hooksExclude, hooksExcludeTests, hooksConvert)
+		logger.Printf("%v\n", err)
+		return nil, nil, err
+	}
+}
+
+func hasHookEvent(hook *release.Hook, event release.HookEvent) bool {
+	for _, e := range hook.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// convertHook strips the helm.sh/hook annotations from the hook's manifest,
+// recording its events and weight in the hook ordering annotations instead
+func convertHook(hook *release.Hook) (string, error) {
+	var manifest map[string]interface{}
+	if err := yaml.Unmarshal([]byte(hook.Manifest), &manifest); err != nil {
+		return "", err
+	}
+	metadata, ok := manifest["metadata"].(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("hook: %v has no metadata", hook.Path)
+	}
+	annotations, _ := metadata["annotations"].(map[string]interface{})
+	if annotations == nil {
+		annotations = map[string]interface{}{}
+	}
+	for name := range annotations {
+		if strings.HasPrefix(name, "helm.sh/hook") {
+			delete(annotations, name)
+		}
+	}
+
+	events := make([]string, 0, len(hook.Events))
+	for _, event := range hook.Events {
+		events = append(events, event.String())
+	}
+	annotations[hookAnnotation] = strings.Join(events, ",")
+	annotations[hookWeightAnnotation] = strconv.Itoa(hook.Weight)
+	metadata["annotations"] = annotations
+
+	converted, err := yaml.Marshal(manifest)
+	if err != nil {
+		return "", err
+	}
+	return string(converted), nil
+}
+
+// validateValues checks the values merged with the chart's defaults against valuesSchema, when set,
+// and against the values.schema.json of the chart and of each of its dependencies.
+// Every violation names its value path and the values source the value came from
+func (p *plugin) validateValues(helmChart *chart.Chart, values map[string]interface{}, sources valueSources) error {
+	mergedValues, err := chartutil.CoalesceValues(helmChart, values)
+	if err != nil {
+		logger.Printf("error coalescing values for chart: %v, error: %v\n", helmChart.Name(), err)
+		return err
+	}
+
+	var violations []string
+	if len(p.ValuesSchema) > 0 {
+		schema, err := utils.LoadFromLoader(p.ldr, p.ValuesSchema, logger)
+		if err != nil {
+			logger.Printf("error loading valuesSchema: %v, error: %v\n", p.ValuesSchema, err)
+			return err
+		}
+		found, err := schemaViolations(mergedValues, schema, "", sources)
+		if err != nil {
+			logger.Printf("error validating values against valuesSchema: %v, error: %v\n", p.ValuesSchema, err)
+			return err
+		}
+		violations = append(violations, found...)
+	}
+
+	var validateChart func(helmChart *chart.Chart, values map[string]interface{}, path string) error
+	validateChart = func(helmChart *chart.Chart, values map[string]interface{}, path string) error {
+		if len(helmChart.Schema) > 0 {
+			found, err := schemaViolations(values, helmChart.Schema, path, sources)
+			if err != nil {
+				logger.Printf("error validating values against the schema of chart: %v, error: %v\n", helmChart.Name(), err)
+				return err
+			}
+			violations = append(violations, found...)
+		}
+		for _, dependency := range helmChart.Dependencies() {
+			dependencyValues, _ := values[dependency.Name()].(map[string]interface{})
+			if err := validateChart(dependency, dependencyValues, joinValuePath(path, dependency.Name())); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := validateChart(helmChart, mergedValues, ""); err != nil {
+		return err
+	}
+
+	if len(violations) > 0 {
+		err := fmt.Errorf("values of chart: %v do not match the values schema:\n%v", helmChart.Name(), strings.Join(violations, "\n"))
+		logger.Printf("%v\n", err)
+		return err
+	}
+	return nil
+}
+
+// schemaViolations validates values, found at path in the chart's values, against schema.
+// Helm reports each violation as a "- <field>: <description>" line
+func schemaViolations(values map[string]interface{}, schema []byte, path string, sources valueSources) ([]string, error) {
+	err := chartutil.ValidateAgainstSingleSchema(values, schema)
+	if err == nil {
+		return nil, nil
+	}
+	var violations []string
+	for _, line := range strings.Split(err.Error(), "\n") {
+		if !strings.HasPrefix(line, "- ") {
+			continue
+		}
+		field, description := "(root)", strings.TrimPrefix(line, "- ")
+		if colon := strings.Index(description, ": "); colon > 0 {
+			field, description = description[:colon], description[colon+2:]
+		}
+		valuePath := path
+		if field != "(root)" {
+			valuePath = joinValuePath(path, field)
+		}
+		violation := fmt.Sprintf("- %v: %v", valuePath, description)
+		if len(valuePath) == 0 {
+			violation = fmt.Sprintf("- (root): %v", description)
+		}
+		if source := sources.lookup(valuePath); len(source) > 0 {
+			violation += fmt.Sprintf(" (from %v)", source)
+		}
+		violations = append(violations, violation)
+	}
+	if len(violations) == 0 {
+		// not a validation failure, most likely the schema itself is invalid
+		return nil, err
+	}
+	return violations, nil
+}
+
+// capabilitiesFile is the format of capabilitiesFrom, the same fields as the plugin config
+type capabilitiesFile struct {
+	KubeVersion string   `json:"kubeVersion,omitempty" yaml:"kubeVersion,omitempty"`
+	APIVersions []string `json:"apiVersions,omitempty" yaml:"apiVersions,omitempty"`
+}
+
+// capabilities returns what .Capabilities holds while rendering: kubeVersion overrides the one in capabilitiesFrom,
+// apiVersions from both are added to helm's default api versions, the way helm template --api-versions does
+func (p *plugin) capabilities() (*chartutil.Capabilities, error) {
+	kubeVersion := p.KubeVersion
+	var apiVersions []string
+	if len(p.CapabilitiesFrom) > 0 {
+		data, err := utils.LoadFromLoader(p.ldr, p.CapabilitiesFrom, logger)
+		if err != nil {
+			logger.Printf("error loading capabilitiesFrom: %v, error: %v\n", p.CapabilitiesFrom, err)
+			return nil, err
+		}
+		var fromFile capabilitiesFile
+		if err := yaml.UnmarshalStrict(data, &fromFile); err != nil {
+			logger.Printf("error unmarshalling capabilitiesFrom: %v, error: %v\n", p.CapabilitiesFrom, err)
+			return nil, err
+		}
+		if len(kubeVersion) == 0 {
+			kubeVersion = fromFile.KubeVersion
+		}
+		apiVersions = append(apiVersions, fromFile.APIVersions...)
+	}
+	apiVersions = append(apiVersions, p.APIVersions...)
+
+	caps := &chartutil.Capabilities{
+		KubeVersion: chartutil.DefaultCapabilities.KubeVersion,
+		APIVersions: append(chartutil.VersionSet{}, chartutil.DefaultCapabilities.APIVersions...),
+	}
+	if len(kubeVersion) > 0 {
+		version, err := semver.NewVersion(kubeVersion)
+		if err != nil {
+			err = fmt.Errorf("invalid kubeVersion: %v, error: %v", kubeVersion, err)
+			logger.Printf("%v\n", err)
+			return nil, err
+		}
+		caps.KubeVersion = chartutil.KubeVersion{
+			Version: "v" + version.String(),
+			Major:   strconv.FormatUint(version.Major(), 10),
+			Minor:   strconv.FormatUint(version.Minor(), 10),
+		}
+	}
+	for _, apiVersion := range apiVersions {
+		if !caps.APIVersions.Has(apiVersion) {
+			caps.APIVersions = append(caps.APIVersions, apiVersion)
+		}
+	}
+	return caps, nil
+}
+
+// mergeValues merges, lowest precedence first: the valuesFrom files left to right, values, the --values files in extraArgs,
+// then set, --set, setString, --set-string, setFile and --set-file.
+// set, setString and setFile entries are applied in key order with helm's --set parsing of the key
+func (p *plugin) mergeValues() (map[string]interface{}, valueSources, error) {
+	values := map[string]interface{}{}
+	sources := valueSources{}
+	for _, valuesFrom := range p.ValuesFrom {
+		data, err := utils.LoadFromLoader(p.ldr, valuesFrom, logger)
+		if err != nil {
+			logger.Printf("error loading valuesFrom: %v, error: %v\n", valuesFrom, err)
+			return nil, nil, err
+		}
+		fileValues, err := chartutil.ReadValues(data)
+		if err != nil {
+			logger.Printf("error parsing valuesFrom: %v, error: %v\n", valuesFrom, err)
+			return nil, nil, err
+		}
+		values = mergeMaps(values, fileValues)
+		sources.record(fileValues, "valuesFrom "+valuesFrom)
+	}
+	values = mergeMaps(values, p.Values)
+	sources.record(p.Values, "values")
+
+	for _, valuesFile := range p.extraValues.values {
+		data, err := p.ldr.Load(valuesFile)
+		if err != nil {
+			logger.Printf("error loading values file: %v, error: %v\n", valuesFile, err)
+			return nil, nil, err
+		}
+		fileValues, err := chartutil.ReadValues(data)
+		if err != nil {
+			logger.Printf("error parsing values file: %v, error: %v\n", valuesFile, err)
+			return nil, nil, err
+		}
+		values = mergeMaps(values, fileValues)
+		sources.record(fileValues, "extraArgs --values "+valuesFile)
+	}
+
+	setValues, err := setExpressions(p.Set)
+	if err != nil {
+		logger.Printf("error in set, error: %v\n", err)
+		return nil, nil, err
+	}
+	setStringValues, err := setExpressions(p.SetString)
+	if err != nil {
+		logger.Printf("error in setString, error: %v\n", err)
+		return nil, nil, err
+	}
+	setFile := make(map[string]interface{}, len(p.SetFile))
+	for key, filePath := range p.SetFile {
+		setFile[key] = filePath
+	}
+	setFileValues, err := setExpressions(setFile)
+	if err != nil {
+		logger.Printf("error in setFile, error: %v\n", err)
+		return nil, nil, err
+	}
+
+	// setFile and --set-file paths are loaded like every other file a kustomization refers to
+	readFile := func(rs []rune) (interface{}, error) {
+		data, err := p.ldr.Load(string(rs))
+		return string(data), err
+	}
+	noFile := func(rs []rune) (interface{}, error) {
+		return "", nil
+	}
+	for _, group := range []struct {
+		source      string
+		expressions []string
+		parse       func(string, map[string]interface{}, strvals.RunesValueReader) error
+	}{
+		{"set", setValues, parseSet},
+		{"extraArgs --set", p.extraValues.set, parseSet},
+		{"setString", setStringValues, parseSetString},
+		{"extraArgs --set-string", p.extraValues.setString, parseSetString},
+		{"setFile", setFileValues, strvals.ParseIntoFile},
+		{"extraArgs --set-file", p.extraValues.setFile, strvals.ParseIntoFile},
+	} {
+		for _, expression := range group.expressions {
+			if err := group.parse(expression, values, readFile); err != nil {
+				logger.Printf("error parsing %v value: %v, error: %v\n", group.source, expression, err)
+				return nil, nil, err
+			}
+			parsed := map[string]interface{}{}
+			_ = group.parse(expression, parsed, noFile)
+			sources.record(parsed, group.source)
+		}
+	}
+	return values, sources, nil
+}
+
+// parseExtraArgs sorts out the helm flags in extraArgs. The values flags are kept for mergeValues. The flags helm template
+// used to receive for rendering are deprecated in favour of the fields that replaced them and set those fields:
+// --kube-version sets kubeVersion, --api-versions (-a) adds to apiVersions, --include-crds sets includeCRDs,
+// --no-hooks and --skip-tests set hooks to exclude and excludeTests. A flag disagreeing with its field is an error,
+// so is any other flag
+func (p *plugin) parseExtraArgs() error {
+	p.extraValues = extraArgValues{}
+	var kubeVersion string
+	var noHooks, skipTests bool
+	for i := 0; i < len(p.ExtraArgs); i++ {
+		flag, value, hasValue := p.ExtraArgs[i], "", false
+		if eq := strings.Index(flag, "="); eq > 0 {
+			flag, value, hasValue = flag[:eq], flag[eq+1:], true
+		}
+		flagValue := func() string {
+			if !hasValue && i+1 < len(p.ExtraArgs) {
+				i++
+				value = p.ExtraArgs[i]
+			}
+			return value
+		}
+		boolFlagValue := func() (bool, error) {
+			if !hasValue {
+				return true, nil
+			}
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return false, fmt.Errorf("extraArgs %v needs true or false, not: %v", flag, value)
+			}
+			return enabled, nil
+		}
+
+		var err error
+		switch flag {
+		case "--set":
+			p.extraValues.set = append(p.extraValues.set, flagValue())
+		case "--set-string":
+			p.extraValues.setString = append(p.extraValues.setString, flagValue())
+		case "--set-file":
+			p.extraValues.setFile = append(p.extraValues.setFile, flagValue())
+		case "--values", "-f":
+			p.extraValues.values = append(p.extraValues.values, flagValue())
+		case "--kube-version":
+			logger.Printf("extraArgs %v is deprecated, use kubeVersion\n", flag)
+			kubeVersion = flagValue()
+		case "--api-versions", "-a":
+			logger.Printf("extraArgs %v is deprecated, use apiVersions\n", flag)
+			for _, apiVersion := range strings.Split(flagValue(), ",") {
+				if len(apiVersion) > 0 && !containsString(p.APIVersions, apiVersion) {
+					p.APIVersions = append(p.APIVersions, apiVersion)
+				}
+			}
+		case "--include-crds":
+			logger.Printf("extraArgs %v is deprecated, use includeCRDs\n", flag)
+			var includeCRDs bool
+			if includeCRDs, err = boolFlagValue(); err == nil {
+				p.IncludeCRDs = p.IncludeCRDs || includeCRDs
+			}
+		case "--no-hooks":
+			logger.Printf("extraArgs %v is deprecated, use hooks: %v\n", flag, hooksExclude)
+			noHooks, err = boolFlagValue()
+		case "--skip-tests":
+			logger.Printf("extraArgs %v is deprecated, use hooks: %v\n", flag, hooksExcludeTests)
+			skipTests, err = boolFlagValue()
+		default:
+			err = fmt.Errorf("unsupported flag in extraArgs: %v", flag)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(kubeVersion) > 0 {
+		if len(p.KubeVersion) > 0 && p.KubeVersion != kubeVersion {
+			return fmt.Errorf("extraArgs --kube-version: %v disagrees with kubeVersion: %v", kubeVersion, p.KubeVersion)
+		}
+		p.KubeVersion = kubeVersion
+	}
+	if noHooks || skipTests {
+		hooks := hooksExcludeTests
+		if noHooks {
+			hooks = hooksExclude
+		}
+		if len(p.Hooks) > 0 && p.Hooks != hooks {
+			return fmt.Errorf("extraArgs --no-hooks and --skip-tests mean hooks: %v, which disagrees with hooks: %v", hooks, p.Hooks)
+		}
+		p.Hooks = hooks
+	}
+	return nil
+}
+
+func parseSet(expression string, values map[string]interface{}, _ strvals.RunesValueReader) error {
+	return strvals.ParseInto(expression, values)
+}
+
+func parseSetString(expression string, values map[string]interface{}, _ strvals.RunesValueReader) error {
+	return strvals.ParseIntoString(expression, values)
+}
+
+// valueSources maps value paths (dot separated, list indexes included) to the values source that last set them
+type valueSources map[string]string
+
+func (s valueSources) record(values map[string]interface{}, source string) {
+	var walk func(path string, value interface{})
+	walk = func(path string, value interface{}) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			for key, child := range v {
+				walk(joinValuePath(path, key), child)
+			}
+		case []interface{}:
+			for i, child := range v {
+				walk(joinValuePath(path, strconv.Itoa(i)), child)
+			}
+		default:
+			s[path] = source
+		}
+	}
+	walk("", values)
+}
+
+// lookup names the source of path, or of the values under it, or of the closest value above it
+func (s valueSources) lookup(path string) string {
+	if len(path) == 0 {
+		return ""
+	}
+	for ancestor := path; ; {
+		if source, ok := s[ancestor]; ok {
+			return source
+		}
+		dot := strings.LastIndex(ancestor, ".")
+		if dot < 0 {
+			break
+		}
+		ancestor = ancestor[:dot]
+	}
+	var found []string
+	for valuePath, source := range s {
+		if strings.HasPrefix(valuePath, path+".") && !containsString(found, source) {
+			found = append(found, source)
+		}
+	}
+	if len(found) == 0 {
+		return "chart defaults"
+	}
+	sort.Strings(found)
+	return strings.Join(found, ", ")
+}
+
+func joinValuePath(path string, key string) string {
+	if len(path) == 0 {
+		return key
+	}
+	return path + "." + key
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// setExpressions turns a set map into --set style key=value expressions in key order,
+// commas in values are escaped so that each entry stays a single expression
+func setExpressions(set map[string]interface{}) ([]string, error) {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	expressions := make([]string, 0, len(keys))
+	for _, key := range keys {
+		var value string
+		switch v := set[key].(type) {
+		case nil:
+			value = "null"
+		case float64:
+			// yaml numbers arrive as float64, keep 1000000 from becoming 1e+06
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		case string, bool, int, int64:
+			value = fmt.Sprint(v)
+		default:
+			return nil, fmt.Errorf("value of: %v must be a scalar, use values for lists and maps", key)
+		}
+		expressions = append(expressions, key+"="+strings.Replace(value, ",", "\\,", -1))
+	}
+	return expressions, nil
+}
+
+// mergeMaps deep merges b into a copy of a, with b winning on conflicts
+func mergeMaps(a, b map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(a))
+	for k, v := range a {
+		out[k] = v
+	}
+	for k, v := range b {
+		if v, ok := v.(map[string]interface{}); ok {
+			if bv, ok := out[k]; ok {
+				if bv, ok := bv.(map[string]interface{}); ok {
+					out[k] = mergeMaps(bv, v)
+					continue
+				}
+			}
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// applyPatches builds the chartPatches kustomization in-process, with its resources replaced by the rendered chart.
+// Both are served from memory so nothing in the chart is modified
+func (p *plugin) applyPatches(templatedHelm []byte) (resmap.ResMap, error) {
+	patchesDir := filepath.Join(p.chartDir, p.ChartPatches)
+	path := filepath.Join(patchesDir, "kustomization.yaml")
+	kustomizeYaml, err := ioutil.ReadFile(path)
+	if err != nil {
+		logger.Printf("error reading file: %v, error: %v\n", path, err)
+		return nil, err
+	}
+
+	var kustomizeYamlMap map[string]interface{}
+	if err := yaml.Unmarshal(kustomizeYaml, &kustomizeYamlMap); err != nil {
+		logger.Printf("error unmarshalling kustomization yaml from file: %v, error: %v\n", path, err)
+		return nil, err
+	}
+	if kustomizeYamlMap == nil {
+		kustomizeYamlMap = map[string]interface{}{}
+	}
+
+	helmOutputPath := filepath.Join(patchesDir, "helmoutput.yaml")
+	kustomizeYamlMap["resources"] = []string{filepath.Base(helmOutputPath)}
+
+	yamlM, err := yaml.Marshal(kustomizeYamlMap)
+	if err != nil {
+		logger.Printf("error marshalling kustomization yaml map, error: %v\n", err)
+		return nil, err
+	}
+
+	return kustomizebuild.Build(patchesDir, map[string][]byte{
+		path:           yamlM,
+		helmOutputPath: templatedHelm,
+	}, p.rf, logger)
+}
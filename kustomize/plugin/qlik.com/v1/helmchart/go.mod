@@ -3,10 +3,21 @@ module github.com/qlik-oss/kustomize-plugins/kustomize/plugin/qlik.com/v1/helmch
 go 1.12
 
 require (
-	github.com/imdario/mergo v0.3.7
+	github.com/Masterminds/semver/v3 v3.1.0
 	github.com/qlik-oss/kustomize-plugins/kustomize/utils v0.0.0
+	github.com/stretchr/testify v1.5.1
+	helm.sh/helm/v3 v3.2.0
 	sigs.k8s.io/kustomize/v3 v3.3.1
-	sigs.k8s.io/yaml v1.1.0
+	sigs.k8s.io/yaml v1.2.0
 )
 
 replace github.com/qlik-oss/kustomize-plugins/kustomize/utils => ../../../../utils
+
+// helm.sh/helm/v3 is built against k8s.io v0.18.0, these are the replacements it declares itself, and the
+// client-go release it needs instead of the v11.0.0+incompatible sigs.k8s.io/kustomize/v3 requires
+replace (
+	github.com/Azure/go-autorest => github.com/Azure/go-autorest v13.3.2+incompatible
+	github.com/docker/distribution => github.com/docker/distribution v0.0.0-20191216044856-a8371794149d
+	github.com/russross/blackfriday => github.com/russross/blackfriday v1.5.2
+	k8s.io/client-go => k8s.io/client-go v0.18.0
+)
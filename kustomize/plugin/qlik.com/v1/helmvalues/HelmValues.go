@@ -1,15 +1,23 @@
 package main
 
 import (
+	"errors"
 	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 
 	"github.com/imdario/mergo"
 	"github.com/qlik-oss/kustomize-plugins/kustomize/utils"
 
+	"sigs.k8s.io/kustomize/v3/pkg/gvk"
 	"sigs.k8s.io/kustomize/v3/pkg/ifc"
 	"sigs.k8s.io/kustomize/v3/pkg/resmap"
+	"sigs.k8s.io/kustomize/v3/pkg/resource"
 	"sigs.k8s.io/kustomize/v3/pkg/transformers"
 	"sigs.k8s.io/kustomize/v3/pkg/transformers/config"
+	"sigs.k8s.io/kustomize/v3/pkg/types"
 	"sigs.k8s.io/yaml"
 )
 
@@ -20,9 +28,29 @@ type plugin struct {
 	ReleaseNamespace string                 `json:"releaseNamespace,omitempty" yaml:"releaseNamespace,omitempty"`
 	FieldSpecs       []config.FieldSpec     `json:"fieldSpecs,omitempty" yaml:"fieldSpecs,omitempty"`
 	Values           map[string]interface{} `json:"values,omitempty" yaml:"values,omitempty"`
+	ListMerge        *utils.ListMerge       `json:"listMergeStrategy,omitempty" yaml:"listMergeStrategy,omitempty"`
+	Target           *target                `json:"target,omitempty" yaml:"target,omitempty"`
+	Set              map[string]interface{} `json:"set,omitempty" yaml:"set,omitempty"`
+	Unset            []string               `json:"unset,omitempty" yaml:"unset,omitempty"`
+	ValuesFiles      []string               `json:"valuesFiles,omitempty" yaml:"valuesFiles,omitempty"`
+	ValuesDir        string                 `json:"valuesDir,omitempty" yaml:"valuesDir,omitempty"`
+	DataSource       map[string]interface{} `json:"dataSource,omitempty" yaml:"dataSource,omitempty"`
 	ValuesName       string
 }
 
+// target selects the HelmChart resources to apply to, chartName and releaseName are regular expressions
+// matched against the whole field the same way name is
+type target struct {
+	types.Selector `json:",inline" yaml:",inline"`
+	ChartName      string `json:"chartName,omitempty" yaml:"chartName,omitempty"`
+	ReleaseName    string `json:"releaseName,omitempty" yaml:"releaseName,omitempty"`
+}
+
+// defaultFieldSpecs merges into the values of HelmChart resources when fieldSpecs is empty
+var defaultFieldSpecs = []config.FieldSpec{
+	{Gvk: gvk.Gvk{Kind: "HelmChart"}, Path: "values", CreateIfNotPresent: true},
+}
+
 //nolint: golint noinspection GoUnusedGlobalVariable
 var KustomizePlugin plugin
 
@@ -33,7 +61,103 @@ func init() {
 }
 
 func (p *plugin) Config(ldr ifc.Loader, rf *resmap.Factory, c []byte) (err error) {
-	return yaml.Unmarshal(c, p)
+	// kustomize configures a fresh copy of the plugin for every HelmValues, the reset only keeps a reused instance from
+	// inheriting fields the new config leaves out
+	*p = plugin{}
+	err = yaml.Unmarshal(c, p)
+	if err != nil {
+		logger.Printf("error unmarshalling config from yaml, error: %v\n", err)
+		return err
+	}
+	if p.ListMerge != nil {
+		if err := p.ListMerge.Validate(); err != nil {
+			logger.Printf("error validating listMergeStrategy, error: %v\n", err)
+			return err
+		}
+	}
+	if p.Target != nil {
+		for _, pattern := range []string{p.Target.ChartName, p.Target.ReleaseName} {
+			if _, err := regexp.Compile(anchorRegex(pattern)); err != nil {
+				logger.Printf("error compiling target regular expression: %v, error: %v\n", pattern, err)
+				return err
+			}
+		}
+	}
+	if len(p.ValuesFiles) > 0 || len(p.ValuesDir) > 0 {
+		if err := p.loadValuesFiles(ldr); err != nil {
+			logger.Printf("error loading values files, error: %v\n", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// loadValuesFiles merges the yaml and json files in valuesDir, in lexical order, then valuesFiles,
+// in the order listed, and overlays the inline values on the result. Files named *.tmpl.yaml are
+// rendered with gomplate against dataSource first
+func (p *plugin) loadValuesFiles(ldr ifc.Loader) error {
+	var files []string
+	if len(p.ValuesDir) > 0 {
+		matches, err := utils.GlobFromLoader(ldr, filepath.Join(p.ValuesDir, "*"), logger)
+		if err != nil {
+			return err
+		}
+		for _, match := range matches {
+			switch filepath.Ext(match) {
+			case ".yaml", ".yml", ".json":
+				files = append(files, match)
+			}
+		}
+	}
+	files = append(files, p.ValuesFiles...)
+
+	values := make(map[string]interface{})
+	for _, file := range files {
+		data, err := utils.LoadFromLoader(ldr, file, logger)
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(file, ".tmpl.yaml") || strings.HasSuffix(file, ".tmpl.yml") {
+			if data, err = p.renderTemplate(ldr, data); err != nil {
+				logger.Printf("error rendering values template: %v, error: %v\n", file, err)
+				return err
+			}
+		}
+		var fileValues map[string]interface{}
+		if err := yaml.Unmarshal(data, &fileValues); err != nil {
+			logger.Printf("error unmarshalling values file: %v, error: %v\n", file, err)
+			return err
+		}
+		if err := p.mergeValuesFile(values, fileValues); err != nil {
+			logger.Printf("error merging values file: %v, error: %v\n", file, err)
+			return err
+		}
+	}
+	if err := p.mergeValuesFile(values, p.Values); err != nil {
+		logger.Printf("error merging inline values, error: %v\n", err)
+		return err
+	}
+	p.Values = values
+	return nil
+}
+
+// mergeValuesFile merges src over values, combining lists the way listMergeStrategy says as values does
+func (p *plugin) mergeValuesFile(values map[string]interface{}, src map[string]interface{}) error {
+	if p.ListMerge != nil {
+		src = p.ListMerge.MergeLists(values, src, "", true)
+	}
+	return mergeValues(&values, src, true)
+}
+
+func (p *plugin) renderTemplate(ldr ifc.Loader, data []byte) ([]byte, error) {
+	if p.DataSource == nil {
+		return nil, errors.New("values templates need a dataSource")
+	}
+	dataSource, env, err := utils.GomplateDataSource(p.DataSource, logger)
+	if err != nil {
+		return nil, err
+	}
+	return utils.RunGomplate(dataSource, ldr.Root(), env, string(data), logger)
 }
 
 func (p *plugin) mutateReleaseNameSpace(in interface{}) (interface{}, error) {
@@ -62,6 +186,14 @@ func (p *plugin) mutateValues(in interface{}) (interface{}, error) {
 	} else {
 		mergeFrom["root"] = p.Values
 	}
+	// mergo replaces lists wholesale, or keeps them when not overwriting, so lists with a strategy are combined first
+	if p.ListMerge != nil {
+		dst, dstIsMap := mergedData["root"].(map[string]interface{})
+		src, srcIsMap := mergeFrom["root"].(map[string]interface{})
+		if dstIsMap && srcIsMap {
+			mergeFrom["root"] = p.ListMerge.MergeLists(dst, src, p.ValuesName, p.Overwrite)
+		}
+	}
 	err = mergeValues(&mergedData, mergeFrom, p.Overwrite)
 	if err != nil {
 		logger.Printf("error executing mergeValues(), error: %v\n", err)
@@ -70,22 +202,71 @@ func (p *plugin) mutateValues(in interface{}) (interface{}, error) {
 	return mergedData["root"], nil
 }
 
+// mutateSetUnset applies unset, then set, to the values, paths are written the way helm's --set writes them
+func (p *plugin) mutateSetUnset(in interface{}) (interface{}, error) {
+	values, ok := in.(map[string]interface{})
+	if !ok || values == nil {
+		values = map[string]interface{}{}
+	}
+	for _, path := range p.Unset {
+		if err := utils.UnsetValue(values, path); err != nil {
+			logger.Printf("error unsetting values path: %v, error: %v\n", path, err)
+			return nil, err
+		}
+	}
+	// sorted so that a path is always set before the paths below it
+	paths := make([]string, 0, len(p.Set))
+	for path := range p.Set {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if err := utils.SetValue(values, path, p.Set[path]); err != nil {
+			logger.Printf("error setting values path: %v, error: %v\n", path, err)
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
 func (p *plugin) Transform(m resmap.ResMap) error {
-	for _, r := range m.Resources() {
-		if isHelmChart(r) {
-			if applyResources(r, p.Chart) {
-				pathToField := []string{"values"}
+	resources, err := p.targetResources(m)
+	if err != nil {
+		logger.Printf("error selecting resources based on the target selector, error: %v\n", err)
+		return err
+	}
+	for _, r := range resources {
+		helmChart := isHelmChart(r)
+		for _, fieldSpec := range p.fieldSpecs() {
+			if !r.GetGvk().IsSelected(&fieldSpec.Gvk) || (helmChart && !applyResources(r, p.Chart)) {
+				continue
+			}
+			pathToField := fieldSpec.PathSlice()
+			err := transformers.MutateField(
+				r.Map(),
+				pathToField,
+				fieldSpec.CreateIfNotPresent,
+				p.mutateValues)
+			if err != nil {
+				logger.Printf("error executing MutateField for chart: %v, pathToField: %v, error: %v\n", p.Chart, pathToField, err)
+				return err
+			}
+			if len(p.Set) > 0 || len(p.Unset) > 0 {
 				err := transformers.MutateField(
 					r.Map(),
 					pathToField,
-					true,
-					p.mutateValues)
+					fieldSpec.CreateIfNotPresent,
+					p.mutateSetUnset)
 				if err != nil {
 					logger.Printf("error executing MutateField for chart: %v, pathToField: %v, error: %v\n", p.Chart, pathToField, err)
 					return err
 				}
 			}
 		}
+		// chartName, the per chart values and the release fields only mean something on a HelmChart
+		if !helmChart {
+			continue
+		}
 		name, err := r.GetString("chartName")
 		if err != nil {
 			logger.Printf("error extracting chartName attribute for chart: %v, error: %v\n", p.Chart, err)
@@ -133,12 +314,46 @@ func (p *plugin) Transform(m resmap.ResMap) error {
 	return nil
 }
 
-func isHelmChart(obj ifc.Kunstructured) bool {
-	kind := obj.GetKind()
-	if kind == "HelmChart" {
-		return true
+// targetResources returns the resources target selects, every resource when there is no target
+func (p *plugin) targetResources(m resmap.ResMap) ([]*resource.Resource, error) {
+	if p.Target == nil {
+		return m.Resources(), nil
 	}
-	return false
+	resources, err := m.Select(p.Target.Selector)
+	if err != nil {
+		return nil, err
+	}
+	chartName := regexp.MustCompile(anchorRegex(p.Target.ChartName))
+	releaseName := regexp.MustCompile(anchorRegex(p.Target.ReleaseName))
+	var selected []*resource.Resource
+	for _, r := range resources {
+		name, _ := r.GetString("chartName")
+		release, _ := r.GetString("releaseName")
+		if chartName.MatchString(name) && releaseName.MatchString(release) {
+			selected = append(selected, r)
+		}
+	}
+	return selected, nil
+}
+
+// anchorRegex anchors pattern to match a whole field, an empty pattern matches anything
+func anchorRegex(pattern string) string {
+	if len(pattern) == 0 {
+		return ".*"
+	}
+	return "^(?:" + pattern + ")$"
+}
+
+// fieldSpecs returns the kinds and paths the values are merged into
+func (p *plugin) fieldSpecs() []config.FieldSpec {
+	if len(p.FieldSpecs) == 0 {
+		return defaultFieldSpecs
+	}
+	return p.FieldSpecs
+}
+
+func isHelmChart(obj ifc.Kunstructured) bool {
+	return obj.GetKind() == "HelmChart"
 }
 
 func applyResources(obj ifc.Kunstructured, chart string) bool {
@@ -0,0 +1,667 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/qlik-oss/kustomize-plugins/kustomize/utils/loadertest"
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/kustomize/v3/k8sdeps/kunstruct"
+	"sigs.k8s.io/kustomize/v3/k8sdeps/transformer"
+	"sigs.k8s.io/kustomize/v3/pkg/ifc"
+	"sigs.k8s.io/kustomize/v3/pkg/resmap"
+	"sigs.k8s.io/kustomize/v3/pkg/resource"
+	"sigs.k8s.io/yaml"
+)
+
+func newTestResourceFactory() *resmap.Factory {
+	return resmap.NewFactory(resource.NewFactory(kunstruct.NewKunstructuredFactoryImpl()), transformer.NewFactoryImpl())
+}
+
+// transform configures the plugin with pluginConfig and runs it over resources
+func transform(t *testing.T, ldr ifc.Loader, pluginConfig string, resources string) resmap.ResMap {
+	rf := newTestResourceFactory()
+	p := plugin{}
+	if err := p.Config(ldr, rf, []byte(pluginConfig)); err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+	resMap, err := rf.NewResMapFromBytes([]byte(resources))
+	if err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+	if err := p.Transform(resMap); err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+	return resMap
+}
+
+// assertFieldValue checks that the field at path of the named resource holds the values expected in yaml
+func assertFieldValue(t *testing.T, resMap resmap.ResMap, name string, path string, expected string) {
+	var expectedValue interface{}
+	if err := yaml.Unmarshal([]byte(expected), &expectedValue); err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+	for _, res := range resMap.Resources() {
+		if res.GetName() != name {
+			continue
+		}
+		value, err := res.GetFieldValue(path)
+		if err != nil {
+			t.Fatalf("Err: %v", err)
+		}
+		assert.Equal(t, expectedValue, value)
+		return
+	}
+	t.Fatalf("Err: no resource named: %v", name)
+}
+
+func TestHelmValuesTarget(t *testing.T) {
+	resources := `
+apiVersion: qlik.com/v1
+kind: HelmChart
+metadata:
+  labels:
+    tier: backend
+  name: engine-a
+chartName: engine
+releaseName: engine-a
+---
+apiVersion: qlik.com/v1
+kind: HelmChart
+metadata:
+  labels:
+    tier: backend
+  name: engine-b
+chartName: engine
+releaseName: engine-b
+---
+apiVersion: qlik.com/v1
+kind: HelmChart
+metadata:
+  name: audit
+chartName: audit
+releaseName: audit
+`
+	testCases := []struct {
+		name                  string
+		pluginConfig          string
+		expectingConfigError  bool
+		expectedValuesTargets []string
+	}{
+		{
+			name: "labels_and_release_name",
+			pluginConfig: `
+target:
+  kind: HelmChart
+  labelSelector: tier=backend
+  releaseName: engine-a
+`,
+			expectedValuesTargets: []string{"engine-a"},
+		},
+		{
+			name: "chart_name_matches_the_whole_field",
+			pluginConfig: `
+target:
+  chartName: engine|aud
+`,
+			expectedValuesTargets: []string{"engine-a", "engine-b"},
+		},
+		{
+			name: "release_name_pattern",
+			pluginConfig: `
+target:
+  releaseName: engine-.*
+`,
+			expectedValuesTargets: []string{"engine-a", "engine-b"},
+		},
+		{
+			name: "name",
+			pluginConfig: `
+target:
+  name: audit
+`,
+			expectedValuesTargets: []string{"audit"},
+		},
+		{
+			name: "chart_name_without_target",
+			pluginConfig: `
+chartName: audit
+`,
+			expectedValuesTargets: []string{"audit"},
+		},
+		{
+			name: "invalid_pattern",
+			pluginConfig: `
+target:
+  chartName: engine(
+`,
+			expectingConfigError: true,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			pluginConfig := `
+apiVersion: qlik.com/v1
+kind: HelmValues
+metadata:
+  name: engine
+values:
+  replicas: 3
+` + testCase.pluginConfig
+			if testCase.expectingConfigError {
+				p := plugin{}
+				assert.Error(t, p.Config(loadertest.NewFakeLoader("/app"), newTestResourceFactory(), []byte(pluginConfig)))
+				return
+			}
+			resMap := transform(t, loadertest.NewFakeLoader("/app"), pluginConfig, resources)
+
+			var valuesTargets []string
+			for _, res := range resMap.Resources() {
+				if _, err := res.GetFieldValue("values.replicas"); err == nil {
+					valuesTargets = append(valuesTargets, res.GetName())
+				}
+			}
+			assert.Equal(t, testCase.expectedValuesTargets, valuesTargets)
+		})
+	}
+}
+
+func TestHelmValuesSetUnset(t *testing.T) {
+	resources := `
+apiVersion: qlik.com/v1
+kind: HelmChart
+metadata:
+  name: qliksense
+chartName: qliksense
+values:
+  image:
+    pullPolicy: Always
+    tag: 1.0.0
+  ingress:
+    hosts:
+    - host: a
+    tls: true
+`
+	testCases := []struct {
+		name                    string
+		pluginConfig            string
+		expectingTransformError bool
+		expectedValues          string
+	}{
+		{
+			name: "set",
+			pluginConfig: `
+set:
+  image.tag: 1.2.3
+  ingress.hosts[0].host: x
+  ingress.hosts[1].host: y
+`,
+			expectedValues: `
+image:
+  pullPolicy: Always
+  tag: 1.2.3
+ingress:
+  hosts:
+  - host: x
+  - host: y
+  tls: true
+`,
+		},
+		{
+			name: "escaped_dots",
+			pluginConfig: `
+set:
+  annotations.qlik\.com/tier: backend
+`,
+			expectedValues: `
+annotations:
+  qlik.com/tier: backend
+image:
+  pullPolicy: Always
+  tag: 1.0.0
+ingress:
+  hosts:
+  - host: a
+  tls: true
+`,
+		},
+		{
+			name: "unset",
+			pluginConfig: `
+unset:
+- image.pullPolicy
+- ingress.tls
+- missing.key
+`,
+			expectedValues: `
+image:
+  tag: 1.0.0
+ingress:
+  hosts:
+  - host: a
+`,
+		},
+		{
+			name: "unset_before_set",
+			pluginConfig: `
+set:
+  image.pullPolicy: IfNotPresent
+unset:
+- image
+`,
+			expectedValues: `
+image:
+  pullPolicy: IfNotPresent
+ingress:
+  hosts:
+  - host: a
+  tls: true
+`,
+		},
+		{
+			name: "set_and_values",
+			pluginConfig: `
+overwrite: true
+values:
+  image:
+    tag: 2.0.0
+set:
+  image.tag: 3.0.0
+`,
+			expectedValues: `
+image:
+  pullPolicy: Always
+  tag: 3.0.0
+ingress:
+  hosts:
+  - host: a
+  tls: true
+`,
+		},
+		{
+			name: "invalid_path",
+			pluginConfig: `
+set:
+  ingress.hosts[x].host: x
+`,
+			expectingTransformError: true,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			rf := newTestResourceFactory()
+			p := plugin{}
+			err := p.Config(loadertest.NewFakeLoader("/app"), rf, []byte(`
+apiVersion: qlik.com/v1
+kind: HelmValues
+metadata:
+  name: qliksense
+chartName: qliksense
+`+testCase.pluginConfig))
+			if err != nil {
+				t.Fatalf("Err: %v", err)
+			}
+			resMap, err := rf.NewResMapFromBytes([]byte(resources))
+			if err != nil {
+				t.Fatalf("Err: %v", err)
+			}
+			err = p.Transform(resMap)
+			if testCase.expectingTransformError {
+				assert.Error(t, err)
+				return
+			}
+			if err != nil {
+				t.Fatalf("Err: %v", err)
+			}
+			assertFieldValue(t, resMap, "qliksense", "values", testCase.expectedValues)
+		})
+	}
+}
+
+func TestHelmValuesFieldSpecs(t *testing.T) {
+	resources := `
+apiVersion: helm.fluxcd.io/v1
+kind: HelmRelease
+metadata:
+  name: engine-release
+spec:
+  releaseName: engine
+  values:
+    replicas: 1
+---
+apiVersion: qlik.com/v1
+kind: Engine
+metadata:
+  name: engine-app
+spec: {}
+---
+apiVersion: qlik.com/v1
+chartName: engine
+kind: HelmChart
+metadata:
+  name: engine-chart
+values:
+  replicas: 1
+`
+	testCases := []struct {
+		name         string
+		pluginConfig string
+		expected     string
+	}{
+		{
+			name: "default_helm_chart_values",
+			expected: `
+apiVersion: helm.fluxcd.io/v1
+kind: HelmRelease
+metadata:
+  name: engine-release
+spec:
+  releaseName: engine
+  values:
+    replicas: 1
+---
+apiVersion: qlik.com/v1
+kind: Engine
+metadata:
+  name: engine-app
+spec: {}
+---
+apiVersion: qlik.com/v1
+chartName: engine
+kind: HelmChart
+metadata:
+  name: engine-chart
+values:
+  image:
+    tag: 1.2.3
+  replicas: 3
+`,
+		},
+		{
+			name: "other_kinds_and_paths",
+			pluginConfig: `
+fieldSpecs:
+- kind: HelmRelease
+  path: spec/values
+- kind: Engine
+  path: spec/helm/values
+  create: true
+`,
+			expected: `
+apiVersion: helm.fluxcd.io/v1
+kind: HelmRelease
+metadata:
+  name: engine-release
+spec:
+  releaseName: engine
+  values:
+    image:
+      tag: 1.2.3
+    replicas: 3
+---
+apiVersion: qlik.com/v1
+kind: Engine
+metadata:
+  name: engine-app
+spec:
+  helm:
+    values:
+      image:
+        tag: 1.2.3
+      replicas: 3
+---
+apiVersion: qlik.com/v1
+chartName: engine
+kind: HelmChart
+metadata:
+  name: engine-chart
+values:
+  replicas: 1
+`,
+		},
+		{
+			name: "missing_path_without_create",
+			pluginConfig: `
+fieldSpecs:
+- kind: Engine
+  path: spec/helm/values
+`,
+			expected: resources,
+		},
+		{
+			// chartName and releaseName only gate and stamp HelmChart resources
+			name: "chart_and_release_name",
+			pluginConfig: `
+chartName: other
+releaseName: engine-prod
+fieldSpecs:
+- kind: HelmRelease
+  path: spec/values
+- kind: HelmChart
+  path: values
+`,
+			expected: `
+apiVersion: helm.fluxcd.io/v1
+kind: HelmRelease
+metadata:
+  name: engine-release
+spec:
+  releaseName: engine
+  values:
+    image:
+      tag: 1.2.3
+    replicas: 3
+---
+apiVersion: qlik.com/v1
+kind: Engine
+metadata:
+  name: engine-app
+spec: {}
+---
+apiVersion: qlik.com/v1
+chartName: engine
+kind: HelmChart
+metadata:
+  name: engine-chart
+releaseName: engine-prod
+values:
+  replicas: 1
+`,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			resMap := transform(t, loadertest.NewFakeLoader("/app"), `
+apiVersion: qlik.com/v1
+kind: HelmValues
+metadata:
+  name: engine
+overwrite: true
+values:
+  replicas: 3
+  image:
+    tag: 1.2.3
+`+testCase.pluginConfig, resources)
+
+			actual, err := resMap.AsYaml()
+			if err != nil {
+				t.Fatalf("Err: %v", err)
+			}
+			assert.Equal(t, strings.TrimPrefix(testCase.expected, "\n"), string(actual))
+		})
+	}
+}
+
+func TestHelmValuesValuesFiles(t *testing.T) {
+	ldr := loadertest.NewFakeLoader("/app")
+	for name, content := range map[string]string{
+		"/app/values/10-base.yaml": `
+replicas: 1
+image:
+  repository: qlik/engine
+  tag: 1.0.0
+extraEnv:
+- name: A
+  value: a
+- name: B
+  value: b
+`,
+		"/app/values/20-prod.json": `{"replicas": 2, "image": {"tag": "1.1.0"}}`,
+		"/app/values/README.md":    `not values`,
+		"/app/customer.yaml": `
+replicas: 3
+extraEnv:
+- name: B
+  value: b2
+`,
+		"/app/values.tmpl.yaml": `
+replicas: {{ .Values.replicas }}
+`,
+		"/app/invalid.yaml": `
+- not a map
+`,
+		"/etc/values.yaml": `
+replicas: 4
+`,
+	} {
+		if err := ldr.AddFile(name, []byte(content)); err != nil {
+			t.Fatalf("Err: %v", err)
+		}
+	}
+
+	testCases := []struct {
+		name                 string
+		pluginConfig         string
+		expectingConfigError bool
+		expectedValues       string
+	}{
+		{
+			name: "valuesDir_then_valuesFiles_then_values",
+			pluginConfig: `
+valuesDir: values
+valuesFiles:
+- customer.yaml
+values:
+  image:
+    tag: 1.2.3
+`,
+			expectedValues: `
+extraEnv:
+- name: B
+  value: b2
+image:
+  repository: qlik/engine
+  tag: 1.2.3
+replicas: 3
+`,
+		},
+		{
+			name: "valuesFiles_in_the_order_listed",
+			pluginConfig: `
+valuesFiles:
+- customer.yaml
+- values/10-base.yaml
+`,
+			expectedValues: `
+extraEnv:
+- name: A
+  value: a
+- name: B
+  value: b
+image:
+  repository: qlik/engine
+  tag: 1.0.0
+replicas: 1
+`,
+		},
+		{
+			name: "listMergeStrategy",
+			pluginConfig: `
+listMergeStrategy:
+  strategy: append
+  paths:
+  - path: extraEnv
+    strategy: mergeByKey
+    key: name
+valuesDir: values
+valuesFiles:
+- customer.yaml
+values:
+  extraEnv:
+  - name: C
+    value: c
+`,
+			expectedValues: `
+extraEnv:
+- name: A
+  value: a
+- name: B
+  value: b2
+- name: C
+  value: c
+image:
+  repository: qlik/engine
+  tag: 1.1.0
+replicas: 3
+`,
+		},
+		{
+			name: "missing_file",
+			pluginConfig: `
+valuesFiles:
+- missing.yaml
+`,
+			expectingConfigError: true,
+		},
+		{
+			name: "file_outside_root",
+			pluginConfig: `
+valuesFiles:
+- ../etc/values.yaml
+`,
+			expectingConfigError: true,
+		},
+		{
+			name: "invalid_file",
+			pluginConfig: `
+valuesFiles:
+- invalid.yaml
+`,
+			expectingConfigError: true,
+		},
+		{
+			name: "template_without_dataSource",
+			pluginConfig: `
+valuesFiles:
+- values.tmpl.yaml
+`,
+			expectingConfigError: true,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			pluginConfig := `
+apiVersion: qlik.com/v1
+kind: HelmValues
+metadata:
+  name: engine
+overwrite: true
+` + testCase.pluginConfig
+			if testCase.expectingConfigError {
+				p := plugin{}
+				assert.Error(t, p.Config(ldr, newTestResourceFactory(), []byte(pluginConfig)))
+				return
+			}
+			resMap := transform(t, ldr, pluginConfig, `
+apiVersion: qlik.com/v1
+kind: HelmChart
+metadata:
+  name: engine
+chartName: engine
+values:
+  replicas: 0
+`)
+			assertFieldValue(t, resMap, "engine", "values", testCase.expectedValues)
+		})
+	}
+}
@@ -75,3 +75,448 @@ values:
 `)
 
 }
+
+func TestHelmValuesListMergeStrategy(t *testing.T) {
+	tc := plugins_test.NewEnvForTest(t).Set()
+	defer tc.Reset()
+
+	tc.BuildGoPlugin(
+		"qlik.com", "v1", "HelmValues")
+	th := kusttest_test.NewKustTestPluginHarness(t, "/app")
+
+	m := th.LoadAndRunTransformer(`
+apiVersion: qlik.com/v1
+kind: HelmValues
+metadata:
+  name: qliksense
+chartName: qliksense
+overwrite: true
+listMergeStrategy:
+  strategy: append
+  paths:
+  - path: extraEnv
+    strategy: mergeByKey
+    key: name
+values:
+  extraEnv:
+  - name: B
+    value: b2
+  - name: C
+    value: c
+  tolerations:
+  - key: two`, `
+apiVersion: apps/v1
+kind: HelmChart
+metadata:
+  name: qliksense
+chartName: qliksense
+releaseName: qliksense
+values:
+  extraEnv:
+  - name: A
+    value: a
+  - name: B
+    value: b
+  tolerations:
+  - key: one
+`)
+
+	th.AssertActualEqualsExpected(m, `
+apiVersion: apps/v1
+chartName: qliksense
+kind: HelmChart
+metadata:
+  name: qliksense
+releaseName: qliksense
+values:
+  extraEnv:
+  - name: A
+    value: a
+  - name: B
+    value: b2
+  - name: C
+    value: c
+  tolerations:
+  - key: one
+  - key: two
+`)
+}
+
+func TestHelmValuesTarget(t *testing.T) {
+	tc := plugins_test.NewEnvForTest(t).Set()
+	defer tc.Reset()
+
+	tc.BuildGoPlugin(
+		"qlik.com", "v1", "HelmValues")
+	th := kusttest_test.NewKustTestPluginHarness(t, "/app")
+
+	m := th.LoadAndRunTransformer(`
+apiVersion: qlik.com/v1
+kind: HelmValues
+metadata:
+  name: engine-a
+overwrite: true
+target:
+  kind: HelmChart
+  labelSelector: tier=backend
+  releaseName: engine-a
+values:
+  replicas: 3`, `
+apiVersion: qlik.com/v1
+kind: HelmChart
+metadata:
+  name: engine-a
+  labels:
+    tier: backend
+chartName: engine
+releaseName: engine-a
+values:
+  replicas: 1
+---
+apiVersion: qlik.com/v1
+kind: HelmChart
+metadata:
+  name: engine-b
+  labels:
+    tier: backend
+chartName: engine
+releaseName: engine-b
+values:
+  replicas: 1
+`)
+
+	th.AssertActualEqualsExpected(m, `
+apiVersion: qlik.com/v1
+chartName: engine
+kind: HelmChart
+metadata:
+  labels:
+    tier: backend
+  name: engine-a
+releaseName: engine-a
+values:
+  replicas: 3
+---
+apiVersion: qlik.com/v1
+chartName: engine
+kind: HelmChart
+metadata:
+  labels:
+    tier: backend
+  name: engine-b
+releaseName: engine-b
+values:
+  replicas: 1
+`)
+}
+
+func TestHelmValuesSetUnset(t *testing.T) {
+	tc := plugins_test.NewEnvForTest(t).Set()
+	defer tc.Reset()
+
+	tc.BuildGoPlugin(
+		"qlik.com", "v1", "HelmValues")
+	th := kusttest_test.NewKustTestPluginHarness(t, "/app")
+
+	m := th.LoadAndRunTransformer(`
+apiVersion: qlik.com/v1
+kind: HelmValues
+metadata:
+  name: qliksense
+chartName: qliksense
+set:
+  image.tag: 1.2.3
+  ingress.hosts[0].host: x
+  annotations.qlik\.com/tier: backend
+unset:
+- image.pullPolicy
+- ingress.tls`, `
+apiVersion: qlik.com/v1
+kind: HelmChart
+metadata:
+  name: qliksense
+chartName: qliksense
+releaseName: qliksense
+values:
+  image:
+    pullPolicy: Always
+    tag: 1.0.0
+  ingress:
+    hosts:
+    - host: a
+    tls: true
+`)
+
+	th.AssertActualEqualsExpected(m, `
+apiVersion: qlik.com/v1
+chartName: qliksense
+kind: HelmChart
+metadata:
+  name: qliksense
+releaseName: qliksense
+values:
+  annotations:
+    qlik.com/tier: backend
+  image:
+    tag: 1.2.3
+  ingress:
+    hosts:
+    - host: x
+`)
+}
+
+func TestHelmValuesFieldSpecs(t *testing.T) {
+	tc := plugins_test.NewEnvForTest(t).Set()
+	defer tc.Reset()
+
+	tc.BuildGoPlugin(
+		"qlik.com", "v1", "HelmValues")
+	th := kusttest_test.NewKustTestPluginHarness(t, "/app")
+
+	m := th.LoadAndRunTransformer(`
+apiVersion: qlik.com/v1
+kind: HelmValues
+metadata:
+  name: engine
+overwrite: true
+fieldSpecs:
+- kind: HelmRelease
+  path: spec/values
+  create: true
+values:
+  replicas: 3
+  image:
+    tag: 1.2.3`, `
+apiVersion: helm.fluxcd.io/v1
+kind: HelmRelease
+metadata:
+  name: engine
+spec:
+  chart:
+    name: engine
+  values:
+    replicas: 1
+---
+apiVersion: qlik.com/v1
+kind: HelmChart
+metadata:
+  name: engine
+chartName: engine
+values:
+  replicas: 1
+`)
+
+	th.AssertActualEqualsExpected(m, `
+apiVersion: helm.fluxcd.io/v1
+kind: HelmRelease
+metadata:
+  name: engine
+spec:
+  chart:
+    name: engine
+  values:
+    image:
+      tag: 1.2.3
+    replicas: 3
+---
+apiVersion: qlik.com/v1
+chartName: engine
+kind: HelmChart
+metadata:
+  name: engine
+values:
+  replicas: 1
+`)
+}
+
+func TestHelmValuesFieldSpecsChartName(t *testing.T) {
+	tc := plugins_test.NewEnvForTest(t).Set()
+	defer tc.Reset()
+
+	tc.BuildGoPlugin(
+		"qlik.com", "v1", "HelmValues")
+	th := kusttest_test.NewKustTestPluginHarness(t, "/app")
+
+	m := th.LoadAndRunTransformer(`
+apiVersion: qlik.com/v1
+kind: HelmValues
+metadata:
+  name: engine
+overwrite: true
+chartName: engine
+releaseName: engine-prod
+fieldSpecs:
+- kind: HelmRelease
+  path: spec/values
+  create: true
+- kind: HelmChart
+  path: values
+  create: true
+values:
+  replicas: 3
+  engine:
+    tier: gold`, `
+apiVersion: helm.fluxcd.io/v1
+kind: HelmRelease
+metadata:
+  name: engine
+spec:
+  releaseName: engine
+  values:
+    replicas: 1
+---
+apiVersion: qlik.com/v1
+kind: HelmChart
+metadata:
+  name: engine
+chartName: engine
+values:
+  replicas: 1
+`)
+
+	th.AssertActualEqualsExpected(m, `
+apiVersion: helm.fluxcd.io/v1
+kind: HelmRelease
+metadata:
+  name: engine
+spec:
+  releaseName: engine
+  values:
+    engine:
+      tier: gold
+    replicas: 3
+---
+apiVersion: qlik.com/v1
+chartName: engine
+kind: HelmChart
+metadata:
+  name: engine
+releaseName: engine-prod
+values:
+  engine:
+    tier: gold
+  replicas: 3
+`)
+}
+
+func TestHelmValuesValuesFiles(t *testing.T) {
+	tc := plugins_test.NewEnvForTest(t).Set()
+	defer tc.Reset()
+
+	tc.BuildGoPlugin(
+		"qlik.com", "v1", "HelmValues")
+	th := kusttest_test.NewKustTestPluginHarness(t, "/app")
+
+	th.WriteF("/app/base.yaml", `
+replicas: 1
+image:
+  repository: qlik/engine
+  tag: 1.0.0
+`)
+	th.WriteF("/app/prod.json", `{"replicas": 3, "image": {"tag": "1.1.0"}}`)
+
+	m := th.LoadAndRunTransformer(`
+apiVersion: qlik.com/v1
+kind: HelmValues
+metadata:
+  name: engine
+overwrite: true
+valuesFiles:
+- base.yaml
+- prod.json
+values:
+  image:
+    tag: 1.2.3`, `
+apiVersion: qlik.com/v1
+kind: HelmChart
+metadata:
+  name: engine
+chartName: engine
+values:
+  replicas: 2
+`)
+
+	th.AssertActualEqualsExpected(m, `
+apiVersion: qlik.com/v1
+chartName: engine
+kind: HelmChart
+metadata:
+  name: engine
+values:
+  image:
+    repository: qlik/engine
+    tag: 1.2.3
+  replicas: 3
+`)
+}
+
+func TestHelmValuesValuesFilesListMergeStrategy(t *testing.T) {
+	tc := plugins_test.NewEnvForTest(t).Set()
+	defer tc.Reset()
+
+	tc.BuildGoPlugin(
+		"qlik.com", "v1", "HelmValues")
+	th := kusttest_test.NewKustTestPluginHarness(t, "/app")
+
+	th.WriteF("/app/base.yaml", `
+extraEnv:
+- name: A
+  value: a
+- name: B
+  value: b
+tolerations:
+- key: one
+`)
+	th.WriteF("/app/prod.yaml", `
+extraEnv:
+- name: B
+  value: b2
+tolerations:
+- key: two
+`)
+
+	m := th.LoadAndRunTransformer(`
+apiVersion: qlik.com/v1
+kind: HelmValues
+metadata:
+  name: engine
+overwrite: true
+listMergeStrategy:
+  strategy: append
+  paths:
+  - path: extraEnv
+    strategy: mergeByKey
+    key: name
+valuesFiles:
+- base.yaml
+- prod.yaml
+values:
+  extraEnv:
+  - name: C
+    value: c`, `
+apiVersion: qlik.com/v1
+kind: HelmChart
+metadata:
+  name: engine
+chartName: engine
+`)
+
+	th.AssertActualEqualsExpected(m, `
+apiVersion: qlik.com/v1
+chartName: engine
+kind: HelmChart
+metadata:
+  name: engine
+values:
+  extraEnv:
+  - name: A
+    value: a
+  - name: B
+    value: b2
+  - name: C
+    value: c
+  tolerations:
+  - key: one
+  - key: two
+`)
+}
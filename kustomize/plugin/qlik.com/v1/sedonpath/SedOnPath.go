@@ -85,7 +85,7 @@ func (p *plugin) executeSed(zString string) (string, error) {
 	for _, regex := range p.Regex {
 		cmd := exec.Command("sed", "-e", regex)
 		cmd.Stdin = bytes.NewBuffer([]byte(zString))
-		output, err := cmd.Output()
+		output, err := utils.RunCommand(cmd, logger)
 		if err != nil {
 			return "", err
 		}
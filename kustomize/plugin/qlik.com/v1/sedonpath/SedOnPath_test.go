@@ -5,6 +5,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/qlik-oss/kustomize-plugins/kustomize/utils"
 	"github.com/qlik-oss/kustomize-plugins/kustomize/utils/loadertest"
 	"github.com/stretchr/testify/assert"
 	"sigs.k8s.io/kustomize/v3/k8sdeps/kunstruct"
@@ -22,6 +23,19 @@ func TestBasicSed(t *testing.T) {
 	assert.Equal(t, "goodbye there!", result)
 }
 
+func TestSedError(t *testing.T) {
+	p := plugin{
+		Regex: []string{"s/unterminated"},
+	}
+	_, err := p.executeSed("hello there!")
+	execErr, ok := err.(*utils.ExecError)
+	if assert.True(t, ok, "expected an *utils.ExecError, got: %v", err) {
+		assert.NotEqual(t, 0, execErr.ExitCode)
+		assert.NotEmpty(t, execErr.Stderr)
+		assert.Contains(t, err.Error(), "s/unterminated")
+	}
+}
+
 func TestSedOnPath(t *testing.T) {
 	basicPluginInputResources := `
 apiVersion: apps/v1
@@ -6,7 +6,11 @@ require (
 	github.com/qlik-oss/kustomize-plugins/kustomize/utils v0.0.0
 	github.com/stretchr/testify v1.4.0
 	sigs.k8s.io/kustomize/v3 v3.3.1
-	sigs.k8s.io/yaml v1.1.0
+	sigs.k8s.io/yaml v1.2.0
 )
 
 replace github.com/qlik-oss/kustomize-plugins/kustomize/utils => ../../../../utils
+
+// SuperConfigMap links k8s.io/client-go through utils/supermapplugin, the replacement in utils does not carry over,
+// so this module replaces the v11.0.0+incompatible sigs.k8s.io/kustomize/v3 requires with the v0.18.0 the CLI links
+replace k8s.io/client-go => k8s.io/client-go v0.18.0
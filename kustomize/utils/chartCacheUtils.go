@@ -0,0 +1,182 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrChartCacheMiss = errors.New("chart not found in the chart cache")
+
+// ChartCache is a content-addressed store of chart archives,
+// archives live under blobs/ by their sha256 digest and refs/ maps repo + chart + version to a digest
+type ChartCache struct {
+	root   string
+	logger *log.Logger
+}
+
+// NewChartCache returns the chart cache under $XDG_CACHE_HOME/qlik-kustomize/charts
+// (or the platform equivalent when XDG_CACHE_HOME is not set)
+func NewChartCache(logger *log.Logger) (*ChartCache, error) {
+	cacheDir := os.Getenv("XDG_CACHE_HOME")
+	if cacheDir == "" {
+		var err error
+		cacheDir, err = os.UserCacheDir()
+		if err != nil {
+			logger.Printf("error locating the user cache directory, error: %v\n", err)
+			return nil, err
+		}
+	}
+	return NewChartCacheAt(filepath.Join(cacheDir, "qlik-kustomize", "charts"), logger), nil
+}
+
+// NewChartCacheAt returns a chart cache rooted at root
+func NewChartCacheAt(root string, logger *log.Logger) *ChartCache {
+	return &ChartCache{root: root, logger: logger}
+}
+
+func (c *ChartCache) Root() string {
+	return c.root
+}
+
+// Digest returns the sha256 digest of archive the same way chart repo indexes record it
+func Digest(archive []byte) string {
+	sum := sha256.Sum256(archive)
+	return hex.EncodeToString(sum[:])
+}
+
+// Get returns the archive cached for the chart version in repoURL
+func (c *ChartCache) Get(repoURL string, chartName string, version string) ([]byte, error) {
+	refPath := c.refPath(repoURL, chartName, version)
+	digest, err := ioutil.ReadFile(refPath)
+	if os.IsNotExist(err) {
+		return nil, ErrChartCacheMiss
+	} else if err != nil {
+		c.logger.Printf("error reading chart cache ref: %v, error: %v\n", refPath, err)
+		return nil, err
+	}
+	return c.GetByDigest(strings.TrimSpace(string(digest)))
+}
+
+// GetByDigest returns the cached archive with the given sha256 digest
+func (c *ChartCache) GetByDigest(digest string) ([]byte, error) {
+	blobPath := c.blobPath(digest)
+	archive, err := ioutil.ReadFile(blobPath)
+	if os.IsNotExist(err) {
+		return nil, ErrChartCacheMiss
+	} else if err != nil {
+		c.logger.Printf("error reading chart cache blob: %v, error: %v\n", blobPath, err)
+		return nil, err
+	}
+	if actual := Digest(archive); actual != digest {
+		err := fmt.Errorf("chart cache blob: %v is corrupt, expected digest: %v, actual digest: %v", blobPath, digest, actual)
+		c.logger.Printf("%v\n", err)
+		return nil, err
+	}
+	return archive, nil
+}
+
+// Put stores archive as the chart version in repoURL and returns its digest
+func (c *ChartCache) Put(repoURL string, chartName string, version string, archive []byte) (string, error) {
+	digest := Digest(archive)
+	if err := c.writeFile(c.blobPath(digest), archive); err != nil {
+		return "", err
+	}
+	if err := c.writeFile(c.refPath(repoURL, chartName, version), []byte(digest)); err != nil {
+		return "", err
+	}
+	return digest, nil
+}
+
+// GetProvenance returns the provenance file cached for the chart version in repoURL
+func (c *ChartCache) GetProvenance(repoURL string, chartName string, version string) ([]byte, error) {
+	provenancePath := c.provenancePath(repoURL, chartName, version)
+	provenance, err := ioutil.ReadFile(provenancePath)
+	if os.IsNotExist(err) {
+		return nil, ErrChartCacheMiss
+	} else if err != nil {
+		c.logger.Printf("error reading chart cache provenance: %v, error: %v\n", provenancePath, err)
+		return nil, err
+	}
+	return provenance, nil
+}
+
+// PutProvenance stores the provenance file of the chart version in repoURL
+func (c *ChartCache) PutProvenance(repoURL string, chartName string, version string, provenance []byte) error {
+	return c.writeFile(c.provenancePath(repoURL, chartName, version), provenance)
+}
+
+// Versions lists the chart versions cached for repoURL
+func (c *ChartCache) Versions(repoURL string, chartName string) ([]string, error) {
+	refDir := filepath.Dir(c.refPath(repoURL, chartName, "any"))
+	files, err := ioutil.ReadDir(refDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		c.logger.Printf("error listing chart cache refs: %v, error: %v\n", refDir, err)
+		return nil, err
+	}
+	versions := make([]string, 0, len(files))
+	for _, file := range files {
+		if file.Mode().IsRegular() && !strings.HasPrefix(file.Name(), ".") {
+			versions = append(versions, file.Name())
+		}
+	}
+	return versions, nil
+}
+
+// Lock serializes fetches of a chart across concurrent builds sharing the cache,
+// the returned func releases the lock
+func (c *ChartCache) Lock(repoURL string, chartName string) (func(), error) {
+	return LockFile(filepath.Join(filepath.Dir(c.refPath(repoURL, chartName, "any")), ".lock"), c.logger)
+}
+
+func (c *ChartCache) blobPath(digest string) string {
+	return filepath.Join(c.root, "blobs", "sha256", digest)
+}
+
+// provenance files live next to the refs, in a dot directory so that Versions never lists them
+func (c *ChartCache) provenancePath(repoURL string, chartName string, version string) string {
+	return filepath.Join(filepath.Dir(c.refPath(repoURL, chartName, version)), ".prov", version)
+}
+
+func (c *ChartCache) refPath(repoURL string, chartName string, version string) string {
+	repoKey := Digest([]byte(strings.TrimSuffix(repoURL, "/")))
+	return filepath.Join(c.root, "refs", repoKey, chartName, version)
+}
+
+// writeFile writes through a temp file and a rename so readers never see a partial file
+func (c *ChartCache) writeFile(filePath string, data []byte) error {
+	dir := filepath.Dir(filePath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		c.logger.Printf("error creating chart cache directory: %v, error: %v\n", dir, err)
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		c.logger.Printf("error creating temp file in: %v, error: %v\n", dir, err)
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		c.logger.Printf("error writing temp file: %v, error: %v\n", tmpFile.Name(), err)
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		c.logger.Printf("error closing temp file: %v, error: %v\n", tmpFile.Name(), err)
+		return err
+	}
+	if err := os.Rename(tmpFile.Name(), filePath); err != nil {
+		c.logger.Printf("error renaming: %v to: %v, error: %v\n", tmpFile.Name(), filePath, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChartCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chart-cache-test")
+	if err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cache := NewChartCacheAt(dir, GetLogger("ChartCacheTest"))
+	repoURL := "https://charts.example.com/stable"
+
+	_, err = cache.Get(repoURL, "foo", "1.0.0")
+	assert.Equal(t, ErrChartCacheMiss, err)
+
+	digest, err := cache.Put(repoURL, "foo", "1.0.0", []byte("foo-1.0.0"))
+	assert.NoError(t, err)
+	assert.Equal(t, Digest([]byte("foo-1.0.0")), digest)
+
+	_, err = cache.Put(repoURL+"/", "foo", "1.1.0", []byte("foo-1.1.0"))
+	assert.NoError(t, err)
+
+	archive, err := cache.Get(repoURL, "foo", "1.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("foo-1.0.0"), archive)
+
+	archive, err = cache.GetByDigest(digest)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("foo-1.0.0"), archive)
+
+	_, err = cache.Get("https://charts.example.com/other", "foo", "1.0.0")
+	assert.Equal(t, ErrChartCacheMiss, err)
+
+	versions, err := cache.Versions(repoURL, "foo")
+	assert.NoError(t, err)
+	sort.Strings(versions)
+	assert.Equal(t, []string{"1.0.0", "1.1.0"}, versions)
+
+	_, err = cache.GetProvenance(repoURL, "foo", "1.0.0")
+	assert.Equal(t, ErrChartCacheMiss, err)
+	assert.NoError(t, cache.PutProvenance(repoURL, "foo", "1.0.0", []byte("foo-1.0.0.prov")))
+	provenance, err := cache.GetProvenance(repoURL, "foo", "1.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("foo-1.0.0.prov"), provenance)
+
+	versions, err = cache.Versions(repoURL, "foo")
+	assert.NoError(t, err)
+	sort.Strings(versions)
+	assert.Equal(t, []string{"1.0.0", "1.1.0"}, versions)
+
+	versions, err = cache.Versions(repoURL, "bar")
+	assert.NoError(t, err)
+	assert.Empty(t, versions)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "blobs", "sha256", digest), []byte("tampered"), 0644)
+	assert.NoError(t, err)
+	_, err = cache.Get(repoURL, "foo", "1.0.0")
+	assert.Error(t, err)
+	assert.NotEqual(t, ErrChartCacheMiss, err)
+}
+
+func TestChartCacheLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chart-cache-test")
+	if err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cache := NewChartCacheAt(dir, GetLogger("ChartCacheTest"))
+	unlock, err := cache.Lock("https://charts.example.com", "foo")
+	assert.NoError(t, err)
+
+	locked := make(chan struct{})
+	go func() {
+		unlock, err := cache.Lock("https://charts.example.com", "foo")
+		assert.NoError(t, err)
+		close(locked)
+		unlock()
+	}()
+
+	select {
+	case <-locked:
+		assert.FailNow(t, "second lock acquired while the first is held")
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		assert.FailNow(t, "second lock never acquired")
+	}
+
+	versions, err := cache.Versions("https://charts.example.com", "foo")
+	assert.NoError(t, err)
+	assert.Empty(t, versions)
+}
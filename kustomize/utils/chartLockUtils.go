@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ChartLockFileName is the name of the chart lock file kept next to a kustomization
+const ChartLockFileName = "helmchart.lock"
+
+// LockedChart pins a chart requested at chartVersion, which may be a semver range,
+// to the version it resolved to and the sha256 digest of that version's archive
+type LockedChart struct {
+	Repo         string `json:"repo" yaml:"repo"`
+	Chart        string `json:"chart" yaml:"chart"`
+	ChartVersion string `json:"chartVersion,omitempty" yaml:"chartVersion,omitempty"`
+	Version      string `json:"version" yaml:"version"`
+	Digest       string `json:"digest" yaml:"digest"`
+}
+
+// ChartLock is the content of a chart lock file
+type ChartLock struct {
+	Charts []LockedChart `json:"charts" yaml:"charts"`
+}
+
+// ReadChartLock reads the chart lock file at path, a missing or empty file is an empty lock
+func ReadChartLock(path string, logger *log.Logger) (*ChartLock, error) {
+	lock := &ChartLock{}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return lock, nil
+	} else if err != nil {
+		logger.Printf("error reading chart lock file: %v, error: %v\n", path, err)
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, lock); err != nil {
+		logger.Printf("error unmarshalling chart lock file: %v, error: %v\n", path, err)
+		return nil, err
+	}
+	return lock, nil
+}
+
+// Find returns the entry for chart in repoURL requested at chartVersion, or nil when there is none
+func (l *ChartLock) Find(repoURL string, chartName string, chartVersion string) *LockedChart {
+	repoURL = strings.TrimSuffix(repoURL, "/")
+	for i := range l.Charts {
+		entry := &l.Charts[i]
+		if entry.Repo == repoURL && entry.Chart == chartName && entry.ChartVersion == chartVersion {
+			return entry
+		}
+	}
+	return nil
+}
+
+// Set adds entry to the lock, replacing the entry for the same repo, chart and chartVersion,
+// entries are kept sorted so that the file only changes when a pin does
+func (l *ChartLock) Set(entry LockedChart) {
+	entry.Repo = strings.TrimSuffix(entry.Repo, "/")
+	if existing := l.Find(entry.Repo, entry.Chart, entry.ChartVersion); existing != nil {
+		*existing = entry
+	} else {
+		l.Charts = append(l.Charts, entry)
+	}
+	sort.Slice(l.Charts, func(i, j int) bool {
+		a, b := l.Charts[i], l.Charts[j]
+		if a.Repo != b.Repo {
+			return a.Repo < b.Repo
+		}
+		if a.Chart != b.Chart {
+			return a.Chart < b.Chart
+		}
+		return a.ChartVersion < b.ChartVersion
+	})
+}
+
+// UpdateChartLock sets entry in the chart lock file at path. The file is locked while it is
+// read and rewritten, so concurrent builds writing to the same lock file never lose an entry
+func UpdateChartLock(path string, entry LockedChart, logger *log.Logger) error {
+	unlock, err := LockFile(path, logger)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	lock, err := ReadChartLock(path, logger)
+	if err != nil {
+		return err
+	}
+	lock.Set(entry)
+	data, err := yaml.Marshal(lock)
+	if err != nil {
+		logger.Printf("error marshalling chart lock file: %v, error: %v\n", path, err)
+		return err
+	}
+	// written in place, a rename would swap the file out from under the lock
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		logger.Printf("error writing chart lock file: %v, error: %v\n", path, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChartLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chart-lock-test")
+	if err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	logger := GetLogger("ChartLockTest")
+	lockPath := filepath.Join(dir, ChartLockFileName)
+	repoURL := "https://charts.example.com/stable"
+
+	lock, err := ReadChartLock(lockPath, logger)
+	assert.NoError(t, err)
+	assert.Empty(t, lock.Charts)
+	assert.Nil(t, lock.Find(repoURL, "foo", "^1.0.0"))
+
+	err = UpdateChartLock(lockPath, LockedChart{Repo: repoURL + "/", Chart: "foo", ChartVersion: "^1.0.0", Version: "1.0.0", Digest: "sha256:aaa"}, logger)
+	assert.NoError(t, err)
+	err = UpdateChartLock(lockPath, LockedChart{Repo: repoURL, Chart: "bar", Version: "2.0.0", Digest: "sha256:bbb"}, logger)
+	assert.NoError(t, err)
+	err = UpdateChartLock(lockPath, LockedChart{Repo: repoURL, Chart: "foo", ChartVersion: "^1.0.0", Version: "1.1.0", Digest: "sha256:ccc"}, logger)
+	assert.NoError(t, err)
+
+	data, err := ioutil.ReadFile(lockPath)
+	assert.NoError(t, err)
+	assert.Equal(t, `charts:
+- chart: bar
+  digest: sha256:bbb
+  repo: https://charts.example.com/stable
+  version: 2.0.0
+- chart: foo
+  chartVersion: ^1.0.0
+  digest: sha256:ccc
+  repo: https://charts.example.com/stable
+  version: 1.1.0
+`, string(data))
+
+	lock, err = ReadChartLock(lockPath, logger)
+	assert.NoError(t, err)
+	locked := lock.Find(repoURL+"/", "foo", "^1.0.0")
+	if assert.NotNil(t, locked) {
+		assert.Equal(t, "1.1.0", locked.Version)
+		assert.Equal(t, "sha256:ccc", locked.Digest)
+	}
+	assert.Nil(t, lock.Find(repoURL, "foo", "1.0.0"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			entry := LockedChart{Repo: repoURL, Chart: fmt.Sprintf("chart-%v", i), Version: "1.0.0", Digest: "sha256:ddd"}
+			assert.NoError(t, UpdateChartLock(lockPath, entry, logger))
+		}(i)
+	}
+	wg.Wait()
+
+	lock, err = ReadChartLock(lockPath, logger)
+	assert.NoError(t, err)
+	assert.Len(t, lock.Charts, 12)
+}
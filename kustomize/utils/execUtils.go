@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// maxStderrLength bounds the stderr an ExecError carries, the end of stderr is kept since that is where tools report what failed
+const maxStderrLength = 4096
+
+// maxArgLength bounds how much of each argument an ExecError message shows, arguments can be whole templates
+const maxArgLength = 256
+
+const redacted = "REDACTED"
+
+// secretNameRegexp matches flag and key names whose values must never show up in errors or logs
+var secretNameRegexp = regexp.MustCompile(`(?i)(password|passwd|token|secret|credential|api[-_]?key|private[-_]?key)`)
+
+// keyValueRegexp matches key=value arguments such as --set expressions, the key being a plain (dotted) name
+var keyValueRegexp = regexp.MustCompile(`^([A-Za-z0-9_.\-\[\]]+)=`)
+
+// ExecError is returned when a command run by a plugin fails, Args have their secrets redacted,
+// ExitCode is -1 when the command never ran or did not exit normally
+type ExecError struct {
+	Command  string
+	Args     []string
+	ExitCode int
+	Stderr   string
+	Err      error
+}
+
+func (e *ExecError) Error() string {
+	command := e.Command
+	for _, arg := range e.Args {
+		if len(arg) > maxArgLength {
+			arg = arg[:maxArgLength] + "..."
+		}
+		command += " " + arg
+	}
+	if e.ExitCode < 0 {
+		return fmt.Sprintf("command: %v failed, error: %v", command, e.Err)
+	}
+	if len(e.Stderr) == 0 {
+		return fmt.Sprintf("command: %v exited with code: %v", command, e.ExitCode)
+	}
+	return fmt.Sprintf("command: %v exited with code: %v, stderr: %v", command, e.ExitCode, e.Stderr)
+}
+
+func (e *ExecError) Unwrap() error {
+	return e.Err
+}
+
+// RunCommand runs cmd and returns its stdout, when it fails the error is an *ExecError holding its stderr
+func RunCommand(cmd *exec.Cmd, logger *log.Logger) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		execErr := &ExecError{
+			Command:  cmd.Path,
+			ExitCode: -1,
+			Stderr:   trimStderr(stderr.String()),
+			Err:      err,
+		}
+		if len(cmd.Args) > 0 {
+			execErr.Args = RedactArgs(cmd.Args[1:])
+		}
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			execErr.ExitCode = exitErr.ExitCode()
+		}
+		logger.Printf("error executing command, error: %v\n", execErr)
+		return nil, execErr
+	}
+	return stdout.Bytes(), nil
+}
+
+// RedactArgs returns a copy of args with the values of secret flags, secret key=value pairs
+// and url passwords replaced
+func RedactArgs(args []string) []string {
+	redactedArgs := make([]string, len(args))
+	redactNext := false
+	for i, arg := range args {
+		switch {
+		case redactNext:
+			redactedArgs[i] = redacted
+			redactNext = false
+		case strings.HasPrefix(arg, "-"):
+			name := strings.TrimLeft(arg, "-")
+			if index := strings.Index(name, "="); index >= 0 {
+				if secretNameRegexp.MatchString(name[:index]) {
+					arg = arg[:len(arg)-len(name)+index+1] + redacted
+				}
+			} else if secretNameRegexp.MatchString(name) {
+				redactNext = true
+			}
+			redactedArgs[i] = redactURL(arg)
+		default:
+			if match := keyValueRegexp.FindStringSubmatch(arg); match != nil && secretNameRegexp.MatchString(match[1]) {
+				arg = match[0] + redacted
+			}
+			redactedArgs[i] = redactURL(arg)
+		}
+	}
+	return redactedArgs
+}
+
+// redactURL drops the password of a url holding one
+func redactURL(arg string) string {
+	index := strings.Index(arg, "://")
+	if index < 0 {
+		return arg
+	}
+	start := strings.LastIndexAny(arg[:index], "= ") + 1
+	u, err := url.Parse(arg[start:])
+	if err != nil || u.User == nil {
+		return arg
+	}
+	if _, ok := u.User.Password(); !ok {
+		return arg
+	}
+	u.User = url.UserPassword(u.User.Username(), redacted)
+	return arg[:start] + u.String()
+}
+
+func trimStderr(stderr string) string {
+	stderr = strings.TrimSpace(stderr)
+	if len(stderr) > maxStderrLength {
+		stderr = "..." + stderr[len(stderr)-maxStderrLength:]
+	}
+	return stderr
+}
@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// GitSource is a directory in a git repository at a ref, written as
+// git+<repository url>?ref=<branch, tag or commit>&path=<directory in the repository>
+type GitSource struct {
+	Repository string
+	Ref        string
+	Path       string
+}
+
+// IsGitSource tells whether source is a git+ url
+func IsGitSource(source string) bool {
+	return strings.HasPrefix(source, "git+")
+}
+
+// ParseGitSource parses a git+ url, ref defaults to the remote HEAD and path to the repository root
+func ParseGitSource(source string) (*GitSource, error) {
+	if !IsGitSource(source) {
+		return nil, fmt.Errorf("git source: %v does not start with git+", source)
+	}
+	u, err := url.Parse(strings.TrimPrefix(source, "git+"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse git source: %v, error: %v", source, err)
+	}
+	query := u.Query()
+	gitSource := &GitSource{
+		Ref:  query.Get("ref"),
+		Path: filepath.Clean("/" + query.Get("path"))[1:],
+	}
+	u.RawQuery = ""
+	gitSource.Repository = u.String()
+	if len(u.Scheme) == 0 || (len(u.Host) == 0 && len(u.Path) == 0) {
+		return nil, fmt.Errorf("git source: %v has no repository url", source)
+	}
+	if strings.HasPrefix(gitSource.Ref, "-") {
+		return nil, fmt.Errorf("git source: %v has a ref starting with -", source)
+	}
+	return gitSource, nil
+}
+
+// Checkout clones the repository into dir, which must not exist yet, checks out ref
+// and returns the directory path points to within the clone
+func (g *GitSource) Checkout(dir string, logger *log.Logger) (string, error) {
+	args := []string{"clone", "--quiet"}
+	if len(g.Ref) > 0 {
+		args = append(args, "--no-checkout")
+	}
+	if _, err := runGit(append(args, "--", g.Repository, dir), logger); err != nil {
+		logger.Printf("error cloning git repository: %v, error: %v\n", g.Repository, err)
+		return "", err
+	}
+	if len(g.Ref) > 0 {
+		commit, err := g.resolveRef(dir, logger)
+		if err != nil {
+			logger.Printf("error resolving ref: %v of git repository: %v, error: %v\n", g.Ref, g.Repository, err)
+			return "", err
+		}
+		if _, err := runGit([]string{"-C", dir, "checkout", "--quiet", commit, "--"}, logger); err != nil {
+			logger.Printf("error checking out ref: %v of git repository: %v, error: %v\n", g.Ref, g.Repository, err)
+			return "", err
+		}
+	}
+	return filepath.Join(dir, g.Path), nil
+}
+
+// resolveRef returns the commit ref names in the clone in dir, a branch other than the default one
+// only exists there as a remote branch. The ref never reaches git where it could be taken for an option
+func (g *GitSource) resolveRef(dir string, logger *log.Logger) (string, error) {
+	if strings.HasPrefix(g.Ref, "-") {
+		return "", fmt.Errorf("git ref: %v starts with -", g.Ref)
+	}
+	var err error
+	for _, ref := range []string{g.Ref, "origin/" + g.Ref} {
+		var commit []byte
+		if commit, err = runGit([]string{"-C", dir, "rev-parse", "--quiet", "--verify", ref + "^{commit}"}, logger); err == nil {
+			return strings.TrimSpace(string(commit)), nil
+		}
+	}
+	return "", err
+}
+
+// runGit runs git without ever prompting for credentials, a build has nobody to answer
+func runGit(args []string, logger *log.Logger) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	return RunCommand(cmd, logger)
+}
@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGitSource(t *testing.T) {
+	testCases := []struct {
+		name        string
+		source      string
+		expected    *GitSource
+		expectError bool
+	}{
+		{
+			name:     "file_with_ref_and_path",
+			source:   "git+file:///repos/charts.git?ref=v1.2.0&path=charts/engine",
+			expected: &GitSource{Repository: "file:///repos/charts.git", Ref: "v1.2.0", Path: "charts/engine"},
+		},
+		{
+			name:     "https_defaults",
+			source:   "git+https://git.example.com/org/charts.git",
+			expected: &GitSource{Repository: "https://git.example.com/org/charts.git"},
+		},
+		{
+			name:     "path_cannot_leave_the_repository",
+			source:   "git+ssh://git@git.example.com/org/charts.git?path=../../etc",
+			expected: &GitSource{Repository: "ssh://git@git.example.com/org/charts.git", Path: "etc"},
+		},
+		{
+			name:        "ref_like_an_option",
+			source:      "git+https://git.example.com/org/charts.git?ref=--orphan=x",
+			expectError: true,
+		},
+		{
+			name:        "not_git",
+			source:      "https://charts.example.com",
+			expectError: true,
+		},
+		{
+			name:        "no_repository",
+			source:      "git+?ref=main",
+			expectError: true,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			gitSource, err := ParseGitSource(testCase.source)
+			if testCase.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expected, gitSource)
+		})
+	}
+}
+
+func TestGitSourceCheckout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-source-test")
+	if err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	logger := GetLogger("GitTest")
+	work := filepath.Join(dir, "work")
+	bare := filepath.Join(dir, "charts.git")
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", work, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		if _, err := RunCommand(cmd, logger); err != nil {
+			t.Fatalf("Err: %v", err)
+		}
+	}
+	writeFile := func(name string, content string) {
+		filePath := filepath.Join(work, name)
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatalf("Err: %v", err)
+		}
+		if err := ioutil.WriteFile(filePath, []byte(content), 0644); err != nil {
+			t.Fatalf("Err: %v", err)
+		}
+	}
+
+	if err := os.MkdirAll(work, 0755); err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+	git("init", "--quiet")
+	writeFile("charts/engine/version", "1")
+	git("add", "-A")
+	git("commit", "--quiet", "-m", "v1")
+	git("tag", "v1")
+	writeFile("charts/engine/version", "2")
+	git("commit", "--quiet", "-a", "-m", "v2")
+	git("branch", "next")
+	git("checkout", "--quiet", "next")
+	writeFile("charts/engine/version", "3")
+	git("commit", "--quiet", "-a", "-m", "v3")
+	git("checkout", "--quiet", "-")
+	if _, err := RunCommand(exec.Command("git", "clone", "--quiet", "--bare", work, bare), logger); err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+
+	testCases := []struct {
+		name            string
+		source          string
+		expectedVersion string
+		expectError     bool
+	}{
+		{
+			name:            "head",
+			source:          "git+file://" + bare + "?path=charts/engine",
+			expectedVersion: "2",
+		},
+		{
+			name:            "tag",
+			source:          "git+file://" + bare + "?ref=v1&path=charts/engine",
+			expectedVersion: "1",
+		},
+		{
+			name:            "branch",
+			source:          "git+file://" + bare + "?ref=next&path=charts/engine",
+			expectedVersion: "3",
+		},
+		{
+			name:        "missing_ref",
+			source:      "git+file://" + bare + "?ref=v9",
+			expectError: true,
+		},
+		{
+			name:        "missing_repository",
+			source:      "git+file://" + filepath.Join(dir, "missing.git"),
+			expectError: true,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			gitSource, err := ParseGitSource(testCase.source)
+			if err != nil {
+				t.Fatalf("Err: %v", err)
+			}
+			chartPath, err := gitSource.Checkout(filepath.Join(dir, "checkout-"+testCase.name), logger)
+			if testCase.expectError {
+				assert.Error(t, err)
+				_, ok := err.(*ExecError)
+				assert.True(t, ok)
+				return
+			}
+			assert.NoError(t, err)
+			version, err := ioutil.ReadFile(filepath.Join(chartPath, "version"))
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expectedVersion, string(version))
+		})
+	}
+}
@@ -3,6 +3,20 @@ module github.com/qlik-oss/kustomize-plugins/kustomize/utils
 go 1.12
 
 require (
+	github.com/go-openapi/jsonreference v0.19.3 // indirect
+	github.com/go-openapi/spec v0.19.3 // indirect
+	github.com/mailru/easyjson v0.7.0 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
 	github.com/stretchr/testify v1.4.0
+	golang.org/x/net v0.0.0-20191004110552-13f9640d40b9 // indirect
+	k8s.io/kube-openapi v0.0.0-20200121204235-bf4fb3bd569c // indirect
 	sigs.k8s.io/kustomize/v3 v3.3.1
+	sigs.k8s.io/yaml v1.2.0
 )
+
+// every plugin requires utils, the indirect requirements above make all of them link the same versions as the
+// helmchart plugin and the CLI built with its dependencies, see scripts/buildKustomizeCLI.sh.
+// kustomizebuild and supermapplugin link k8s.io/client-go, which sigs.k8s.io/kustomize/v3 requires at
+// v11.0.0+incompatible, a version no requirement can lower, so only a replacement gets them the v0.18.0 helmchart
+// needs. Replacements do not carry over to the modules requiring utils, the plugins linking those packages repeat it
+replace k8s.io/client-go => k8s.io/client-go v0.18.0
@@ -1,12 +1,14 @@
 package utils
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 )
 
 func RunGomplate(dataSource string, pwd string, env []string, temp string, logger *log.Logger) ([]byte, error) {
@@ -18,13 +20,55 @@ func RunGomplate(dataSource string, pwd string, env []string, temp string, logge
 
 	gomplateCmd.Env = append(os.Environ(), env...)
 
-	var out bytes.Buffer
-	gomplateCmd.Stdout = &out
-	err := gomplateCmd.Run()
+	return RunCommand(gomplateCmd, logger)
+}
 
-	if err != nil {
-		logger.Printf("error executing command: %v with args: %v, error: %v\n", gomplateCmd.Path, gomplateCmd.Args, err)
-		return nil, err
+// GomplateDataSource resolves a dataSource config, shaped like the Gomplate plugin's, into the data source path
+// and the environment RunGomplate needs for it: ejson (filePath and privateKeyPath, falling back to EJSON_KEY in the environment),
+// vault (secretPath, addressPath and tokenPath) or file (path)
+func GomplateDataSource(dataSource map[string]interface{}, logger *log.Logger) (string, []string, error) {
+	section := func(name string) map[string]interface{} {
+		config, _ := dataSource[name].(map[string]interface{})
+		return config
+	}
+	field := func(config map[string]interface{}, name string) string {
+		value, _ := config[name].(string)
+		return value
+	}
+	readEnv := func(name string, path string) (string, error) {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			logger.Printf("error reading file: %v for: %v, error: %v\n", path, name, err)
+			return "", err
+		}
+		return fmt.Sprintf("%s=%s", name, strings.TrimSpace(string(data))), nil
+	}
+
+	if ejson := section("ejson"); ejson != nil {
+		var env []string
+		if keyPath := field(ejson, "privateKeyPath"); len(keyPath) > 0 {
+			if keyEnv, err := readEnv("EJSON_KEY", keyPath); err == nil {
+				env = append(env, keyEnv)
+			}
+		}
+		if key := os.Getenv("EJSON_KEY"); len(env) == 0 && len(key) > 0 {
+			env = append(env, fmt.Sprintf("EJSON_KEY=%s", key))
+		}
+		return field(ejson, "filePath"), env, nil
+	}
+	if vault := section("vault"); vault != nil {
+		addressEnv, err := readEnv("VAULT_ADDR", field(vault, "addressPath"))
+		if err != nil {
+			return "", nil, err
+		}
+		tokenEnv, err := readEnv("VAULT_TOKEN", field(vault, "tokenPath"))
+		if err != nil {
+			return "", nil, err
+		}
+		return field(vault, "secretPath"), []string{addressEnv, tokenEnv}, nil
+	}
+	if file := section("file"); file != nil {
+		return field(file, "path"), nil, nil
 	}
-	return out.Bytes(), nil
+	return "", nil, errors.New("dataSource needs one of: ejson, vault, file")
 }
@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGomplateDataSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gomplate-test")
+	if err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, content := range map[string]string{"ejson.key": "secret-key\n", "vault.addr": "https://vault:8200\n", "vault.token": "s.token"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Err: %v", err)
+		}
+	}
+
+	if err := os.Setenv("EJSON_KEY", "env-key"); err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+	defer os.Unsetenv("EJSON_KEY")
+
+	testCases := []struct {
+		name               string
+		dataSource         map[string]interface{}
+		expectedDataSource string
+		expectedEnv        []string
+		expectError        bool
+	}{
+		{
+			name:               "file",
+			dataSource:         map[string]interface{}{"file": map[string]interface{}{"path": "data.yaml"}},
+			expectedDataSource: "data.yaml",
+		},
+		{
+			name: "ejson",
+			dataSource: map[string]interface{}{"ejson": map[string]interface{}{
+				"filePath":       "secrets.ejson",
+				"privateKeyPath": filepath.Join(dir, "ejson.key"),
+			}},
+			expectedDataSource: "secrets.ejson",
+			expectedEnv:        []string{"EJSON_KEY=secret-key"},
+		},
+		{
+			name: "ejson_missing_key_falls_back_to_environment",
+			dataSource: map[string]interface{}{"ejson": map[string]interface{}{
+				"filePath":       "secrets.ejson",
+				"privateKeyPath": filepath.Join(dir, "missing"),
+			}},
+			expectedDataSource: "secrets.ejson",
+			expectedEnv:        []string{"EJSON_KEY=env-key"},
+		},
+		{
+			name: "vault",
+			dataSource: map[string]interface{}{"vault": map[string]interface{}{
+				"secretPath":  "vault:///secret/data",
+				"addressPath": filepath.Join(dir, "vault.addr"),
+				"tokenPath":   filepath.Join(dir, "vault.token"),
+			}},
+			expectedDataSource: "vault:///secret/data",
+			expectedEnv:        []string{"VAULT_ADDR=https://vault:8200", "VAULT_TOKEN=s.token"},
+		},
+		{
+			name: "vault_missing_token",
+			dataSource: map[string]interface{}{"vault": map[string]interface{}{
+				"addressPath": filepath.Join(dir, "vault.addr"),
+				"tokenPath":   filepath.Join(dir, "missing"),
+			}},
+			expectError: true,
+		},
+		{
+			name:        "none",
+			expectError: true,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			dataSource, env, err := GomplateDataSource(testCase.dataSource, GetLogger("GomplateTest"))
+			if testCase.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expectedDataSource, dataSource)
+			assert.Equal(t, testCase.expectedEnv, env)
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+)
+
+// HTTPOptions holds the credentials and tls settings used to reach a chart repo or registry.
+// Username and Password are sent as basic auth, Token as a bearer token
+type HTTPOptions struct {
+	Username              string
+	Password              string
+	Token                 string
+	CAFile                string
+	CertFile              string
+	KeyFile               string
+	InsecureSkipTLSVerify bool
+}
+
+// HasTLS is true when any tls setting is configured
+func (o *HTTPOptions) HasTLS() bool {
+	return o != nil && (len(o.CAFile) > 0 || len(o.CertFile) > 0 || len(o.KeyFile) > 0 || o.InsecureSkipTLSVerify)
+}
+
+// Validate fails when only one of CertFile and KeyFile is set, a client certificate needs both
+func (o *HTTPOptions) Validate() error {
+	if o == nil {
+		return nil
+	}
+	if len(o.CertFile) > 0 && len(o.KeyFile) == 0 {
+		return fmt.Errorf("certFile: %v needs a keyFile", o.CertFile)
+	}
+	if len(o.KeyFile) > 0 && len(o.CertFile) == 0 {
+		return fmt.Errorf("keyFile: %v needs a certFile", o.KeyFile)
+	}
+	return nil
+}
+
+// Client returns an http client using the tls settings, the default client when there are none
+func (o *HTTPOptions) Client() (*http.Client, error) {
+	if !o.HasTLS() {
+		return http.DefaultClient, nil
+	}
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: o.InsecureSkipTLSVerify}
+	if len(o.CAFile) > 0 {
+		caBytes, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read caFile: %v, error: %v", o.CAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caBytes) {
+			return nil, fmt.Errorf("caFile: %v holds no pem certificates", o.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(o.CertFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load certFile: %v and keyFile: %v, error: %v", o.CertFile, o.KeyFile, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return &http.Client{Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig}}, nil
+}
+
+func (o *HTTPOptions) authorize(request *http.Request) {
+	if o == nil {
+		return
+	}
+	if len(o.Token) > 0 {
+		request.Header.Set("Authorization", "Bearer "+o.Token)
+	} else if len(o.Username) > 0 || len(o.Password) > 0 {
+		request.SetBasicAuth(o.Username, o.Password)
+	}
+}
+
+// fetch the body of url, anything other than a 2xx response is an error
+func HTTPGet(url string, logger *log.Logger) ([]byte, error) {
+	return HTTPGetWithOptions(url, nil, logger)
+}
+
+// HTTPGetWithOptions is HTTPGet using the credentials and tls settings in options, which may be nil
+func HTTPGetWithOptions(url string, options *HTTPOptions, logger *log.Logger) ([]byte, error) {
+	client, err := options.Client()
+	if err != nil {
+		logger.Printf("error creating http client for url: %v, error: %v\n", url, err)
+		return nil, err
+	}
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		logger.Printf("error creating request for url: %v, error: %v\n", url, err)
+		return nil, err
+	}
+	options.authorize(request)
+
+	response, err := client.Do(request)
+	if err != nil {
+		logger.Printf("error executing GET for url: %v, error: %v\n", url, err)
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		err := fmt.Errorf("failed to fetch %v : %v", url, response.Status)
+		logger.Printf("error executing GET for url: %v, error: %v\n", url, err)
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		logger.Printf("error reading response body for url: %v, error: %v\n", url, err)
+		return nil, err
+	}
+	return body, nil
+}
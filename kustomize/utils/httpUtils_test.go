@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeServerCA(t *testing.T, dir string, server *httptest.Server) string {
+	caFile := filepath.Join(dir, "ca.pem")
+	caBytes := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, caBytes, 0644); err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+	return caFile
+}
+
+func TestHTTPGetWithOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "http-utils-test")
+	if err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if r.Header.Get("Authorization") != "Bearer secret-token" && (!ok || username != "user" || password != "pass") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte("index"))
+	}))
+	defer server.Close()
+	caFile := writeServerCA(t, dir, server)
+	logger := GetLogger("HTTPUtilsTest")
+
+	testCases := []struct {
+		name          string
+		options       *HTTPOptions
+		expectingBody string
+	}{
+		{
+			name:    "untrusted_certificate",
+			options: &HTTPOptions{Username: "user", Password: "pass"},
+		},
+		{
+			name:    "missing_credentials",
+			options: &HTTPOptions{CAFile: caFile},
+		},
+		{
+			name:          "basic_auth",
+			options:       &HTTPOptions{CAFile: caFile, Username: "user", Password: "pass"},
+			expectingBody: "index",
+		},
+		{
+			name:          "bearer_token",
+			options:       &HTTPOptions{CAFile: caFile, Token: "secret-token"},
+			expectingBody: "index",
+		},
+		{
+			name:          "insecure_skip_tls_verify",
+			options:       &HTTPOptions{InsecureSkipTLSVerify: true, Token: "secret-token"},
+			expectingBody: "index",
+		},
+		{
+			name:    "key_file_without_cert_file",
+			options: &HTTPOptions{InsecureSkipTLSVerify: true, KeyFile: filepath.Join(dir, "client.key"), Token: "secret-token"},
+		},
+		{
+			name:    "missing_ca_file",
+			options: &HTTPOptions{CAFile: filepath.Join(dir, "missing.pem"), Token: "secret-token"},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			body, err := HTTPGetWithOptions(server.URL+"/index.yaml", testCase.options, logger)
+			if len(testCase.expectingBody) == 0 {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expectingBody, string(body))
+		})
+	}
+}
+
+func TestHTTPOptionsValidate(t *testing.T) {
+	testCases := []struct {
+		name           string
+		options        *HTTPOptions
+		expectingError bool
+	}{
+		{name: "nil"},
+		{name: "no_tls", options: &HTTPOptions{Token: "secret-token"}},
+		{name: "cert_and_key", options: &HTTPOptions{CertFile: "client.crt", KeyFile: "client.key"}},
+		{name: "cert_without_key", options: &HTTPOptions{CertFile: "client.crt"}, expectingError: true},
+		{name: "key_without_cert", options: &HTTPOptions{KeyFile: "client.key"}, expectingError: true},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := testCase.options.Validate()
+			if testCase.expectingError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"regexp"
+
+	"sigs.k8s.io/yaml"
+)
+
+// builtinClusterScopedKinds are the cluster-scoped kinds of the kubernetes api, by group
+var builtinClusterScopedKinds = map[string][]string{
+	"":                             {"ComponentStatus", "Namespace", "Node", "PersistentVolume"},
+	"admissionregistration.k8s.io": {"MutatingWebhookConfiguration", "ValidatingWebhookConfiguration"},
+	"apiextensions.k8s.io":         {"CustomResourceDefinition"},
+	"apiregistration.k8s.io":       {"APIService"},
+	"authentication.k8s.io":        {"TokenReview"},
+	"authorization.k8s.io":         {"SelfSubjectAccessReview", "SelfSubjectRulesReview", "SubjectAccessReview"},
+	"certificates.k8s.io":          {"CertificateSigningRequest"},
+	"extensions":                   {"PodSecurityPolicy"},
+	"flowcontrol.apiserver.k8s.io": {"FlowSchema", "PriorityLevelConfiguration"},
+	"networking.k8s.io":            {"IngressClass"},
+	"node.k8s.io":                  {"RuntimeClass"},
+	"policy":                       {"PodSecurityPolicy"},
+	"rbac.authorization.k8s.io":    {"ClusterRole", "ClusterRoleBinding"},
+	"scheduling.k8s.io":            {"PriorityClass"},
+	"storage.k8s.io":               {"CSIDriver", "CSINode", "StorageClass", "VolumeAttachment"},
+}
+
+var yamlDocumentSeparator = regexp.MustCompile(`(?m)^---[ \t]*$`)
+
+// KindScopes tells cluster-scoped kinds from namespaced ones, starting from the kubernetes api
+// and extended with the scopes CRDs declare. Kinds it knows nothing about are namespaced
+type KindScopes map[string]bool
+
+// NewKindScopes returns the scopes of the kubernetes api kinds
+func NewKindScopes() KindScopes {
+	scopes := KindScopes{}
+	for group, kinds := range builtinClusterScopedKinds {
+		for _, kind := range kinds {
+			scopes.Set(group, kind, true)
+		}
+	}
+	return scopes
+}
+
+// Set records whether kind in group is cluster-scoped
+func (s KindScopes) Set(group string, kind string, clusterScoped bool) {
+	s[group+"/"+kind] = clusterScoped
+}
+
+// IsClusterScoped tells whether kind in group is cluster-scoped
+func (s KindScopes) IsClusterScoped(group string, kind string) bool {
+	return s[group+"/"+kind]
+}
+
+// AddCRD records the scope of the kind crd defines, anything that is not a CRD is ignored
+func (s KindScopes) AddCRD(crd map[string]interface{}) {
+	if crd["kind"] != "CustomResourceDefinition" {
+		return
+	}
+	spec, _ := crd["spec"].(map[string]interface{})
+	names, _ := spec["names"].(map[string]interface{})
+	group, _ := spec["group"].(string)
+	kind, _ := names["kind"].(string)
+	scope, _ := spec["scope"].(string)
+	if len(kind) == 0 {
+		return
+	}
+	// the api server defaults scope to Namespaced
+	s.Set(group, kind, scope == "Cluster")
+}
+
+// AddCRDs records the scopes of the CRDs among the yaml documents in manifests
+func (s KindScopes) AddCRDs(manifests []byte) error {
+	for _, document := range yamlDocumentSeparator.Split(string(manifests), -1) {
+		var crd map[string]interface{}
+		if err := yaml.Unmarshal([]byte(document), &crd); err != nil {
+			return err
+		}
+		s.AddCRD(crd)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKindScopes(t *testing.T) {
+	scopes := NewKindScopes()
+	assert.True(t, scopes.IsClusterScoped("", "Namespace"))
+	assert.True(t, scopes.IsClusterScoped("rbac.authorization.k8s.io", "ClusterRole"))
+	assert.False(t, scopes.IsClusterScoped("rbac.authorization.k8s.io", "Role"))
+	assert.False(t, scopes.IsClusterScoped("apps", "Deployment"))
+	assert.False(t, scopes.IsClusterScoped("", "ClusterRole"))
+
+	err := scopes.AddCRDs([]byte(`
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+spec:
+  group: example.com
+  scope: Cluster
+  names:
+    kind: Widget
+---
+apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: gadgets.example.com
+spec:
+  group: example.com
+  names:
+    kind: Gadget
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: not-a-crd
+`))
+	assert.NoError(t, err)
+	assert.True(t, scopes.IsClusterScoped("example.com", "Widget"))
+	assert.False(t, scopes.IsClusterScoped("example.com", "Gadget"))
+	assert.False(t, scopes.IsClusterScoped("", "ConfigMap"))
+
+	scopes.Set("example.com", "Widget", false)
+	assert.False(t, scopes.IsClusterScoped("example.com", "Widget"))
+
+	assert.Error(t, scopes.AddCRDs([]byte("kind: [")))
+}
@@ -0,0 +1,97 @@
+// Package kustomizebuild builds kustomizations in-process. It links the kustomize build machinery and with it
+// k8s.io/client-go, so it is kept out of utils, which every plugin links.
+package kustomizebuild
+
+import (
+	"log"
+	"path/filepath"
+
+	"sigs.k8s.io/kustomize/v3/k8sdeps/transformer"
+	"sigs.k8s.io/kustomize/v3/k8sdeps/validator"
+	"sigs.k8s.io/kustomize/v3/pkg/fs"
+	"sigs.k8s.io/kustomize/v3/pkg/loader"
+	"sigs.k8s.io/kustomize/v3/pkg/plugins"
+	"sigs.k8s.io/kustomize/v3/pkg/resmap"
+	"sigs.k8s.io/kustomize/v3/pkg/target"
+)
+
+// Build builds the kustomization in dir in-process,
+// virtualFiles (keyed by absolute path) are read in place of, or in addition to, the files on disk and are never written
+func Build(dir string, virtualFiles map[string][]byte, rf *resmap.Factory, logger *log.Logger) (resmap.ResMap, error) {
+	fSys := &overlayFs{FileSystem: fs.MakeFsOnDisk(), files: make(map[string][]byte, len(virtualFiles))}
+	for filePath, data := range virtualFiles {
+		fSys.files[filepath.Clean(filePath)] = data
+		// the loader works on symlink free paths, so the files are found under either
+		if dir, err := filepath.EvalSymlinks(filepath.Dir(filePath)); err == nil {
+			fSys.files[filepath.Join(dir, filepath.Base(filePath))] = data
+		}
+	}
+
+	ldr, err := loader.NewLoader(loader.RestrictionNone, validator.NewKustValidator(), dir, fSys)
+	if err != nil {
+		logger.Printf("error creating kustomize loader for: %v, error: %v\n", dir, err)
+		return nil, err
+	}
+	defer ldr.Cleanup()
+
+	kt, err := target.NewKustTarget(ldr, rf, transformer.NewFactoryImpl(), plugins.NewLoader(plugins.ActivePluginConfig(), rf))
+	if err != nil {
+		logger.Printf("error creating kustomize target for: %v, error: %v\n", dir, err)
+		return nil, err
+	}
+	resMap, err := kt.MakeCustomizedResMap()
+	if err != nil {
+		logger.Printf("error building kustomization: %v, error: %v\n", dir, err)
+		return nil, err
+	}
+	return resMap, nil
+}
+
+// overlayFs is the on disk file system with some files served from memory
+type overlayFs struct {
+	fs.FileSystem
+	files map[string][]byte
+}
+
+func (o *overlayFs) virtualFile(path string) ([]byte, bool) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, false
+	}
+	data, ok := o.files[absPath]
+	return data, ok
+}
+
+func (o *overlayFs) ReadFile(path string) ([]byte, error) {
+	if data, ok := o.virtualFile(path); ok {
+		return data, nil
+	}
+	return o.FileSystem.ReadFile(path)
+}
+
+func (o *overlayFs) Exists(path string) bool {
+	if _, ok := o.virtualFile(path); ok {
+		return true
+	}
+	return o.FileSystem.Exists(path)
+}
+
+func (o *overlayFs) IsDir(path string) bool {
+	if _, ok := o.virtualFile(path); ok {
+		return false
+	}
+	return o.FileSystem.IsDir(path)
+}
+
+// CleanedAbs of a virtual file cannot resolve symlinks on disk, its directory must exist
+func (o *overlayFs) CleanedAbs(path string) (fs.ConfirmedDir, string, error) {
+	if _, ok := o.virtualFile(path); ok {
+		absPath, _ := filepath.Abs(path)
+		dir, _, err := o.FileSystem.CleanedAbs(filepath.Dir(absPath))
+		if err != nil {
+			return "", "", err
+		}
+		return dir, filepath.Base(absPath), nil
+	}
+	return o.FileSystem.CleanedAbs(path)
+}
@@ -0,0 +1,72 @@
+package kustomizebuild
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/qlik-oss/kustomize-plugins/kustomize/utils"
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/kustomize/v3/k8sdeps/kunstruct"
+	"sigs.k8s.io/kustomize/v3/k8sdeps/transformer"
+	"sigs.k8s.io/kustomize/v3/pkg/resmap"
+	"sigs.k8s.io/kustomize/v3/pkg/resource"
+)
+
+func TestBuild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kustomize-utils-test")
+	if err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	kustomization := []byte(`
+resources:
+- output.yaml
+patchesStrategicMerge:
+- patch.yaml
+`)
+	if err := ioutil.WriteFile(filepath.Join(dir, "kustomization.yaml"), []byte("resources:\n- missing.yaml\n"), 0644); err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "patch.yaml"), []byte(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+data:
+  patched: "true"
+`), 0644); err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+
+	resourceFactory := resmap.NewFactory(resource.NewFactory(
+		kunstruct.NewKunstructuredFactoryImpl()), transformer.NewFactoryImpl())
+	resMap, err := Build(dir, map[string][]byte{
+		filepath.Join(dir, "kustomization.yaml"): kustomization,
+		filepath.Join(dir, "output.yaml"): []byte(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+data:
+  original: "true"
+`),
+	}, resourceFactory, utils.GetLogger("KustomizeBuildTest"))
+	if err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+
+	assert.Equal(t, 1, resMap.Size())
+	data, err := resMap.GetByIndex(0).GetFieldValue("data")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"original": "true", "patched": "true"}, data)
+
+	// nothing virtual is ever written
+	_, err = os.Stat(filepath.Join(dir, "output.yaml"))
+	assert.True(t, os.IsNotExist(err))
+	onDisk, err := ioutil.ReadFile(filepath.Join(dir, "kustomization.yaml"))
+	assert.NoError(t, err)
+	assert.Equal(t, "resources:\n- missing.yaml\n", string(onDisk))
+}
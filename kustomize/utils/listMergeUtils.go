@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const (
+	ListMergeReplace    = "replace"
+	ListMergeAppend     = "append"
+	ListMergePrepend    = "prepend"
+	ListMergeMergeByKey = "mergeByKey"
+)
+
+// ListMergeStrategy is how a list in the values being merged is combined with the list already there:
+// replace, append, prepend, or mergeByKey, which merges the items having the same value at key
+// and appends the rest. Path is the dotted path of the list in the values
+type ListMergeStrategy struct {
+	Path     string `json:"path,omitempty" yaml:"path,omitempty"`
+	Strategy string `json:"strategy,omitempty" yaml:"strategy,omitempty"`
+	Key      string `json:"key,omitempty" yaml:"key,omitempty"`
+}
+
+// ListMerge holds the strategy for every list, if any, and the strategies for the lists at given paths
+type ListMerge struct {
+	ListMergeStrategy `json:",inline" yaml:",inline"`
+	Paths             []ListMergeStrategy `json:"paths,omitempty" yaml:"paths,omitempty"`
+}
+
+// Validate fails on unknown strategies and on mergeByKey without a key
+func (l *ListMerge) Validate() error {
+	if len(l.Path) > 0 {
+		return fmt.Errorf("listMergeStrategy: path: %v belongs in paths", l.Path)
+	}
+	strategies := append([]ListMergeStrategy{l.ListMergeStrategy}, l.Paths...)
+	for i, strategy := range strategies {
+		if i > 0 && len(strategy.Path) == 0 {
+			return fmt.Errorf("listMergeStrategy: paths[%v] has no path", i-1)
+		}
+		switch strategy.Strategy {
+		case "":
+			if i > 0 {
+				return fmt.Errorf("listMergeStrategy: path: %v has no strategy", strategy.Path)
+			}
+		case ListMergeReplace, ListMergeAppend, ListMergePrepend:
+		case ListMergeMergeByKey:
+			if len(strategy.Key) == 0 {
+				return fmt.Errorf("listMergeStrategy: %v needs a key", ListMergeMergeByKey)
+			}
+		default:
+			return fmt.Errorf("listMergeStrategy: unknown strategy: %v, expected one of: %v, %v, %v, %v",
+				strategy.Strategy, ListMergeReplace, ListMergeAppend, ListMergePrepend, ListMergeMergeByKey)
+		}
+	}
+	return nil
+}
+
+// strategyFor returns the strategy for the list at path, nil when lists there are merged the default way
+func (l *ListMerge) strategyFor(path string) *ListMergeStrategy {
+	for i := range l.Paths {
+		if strings.Trim(l.Paths[i].Path, ".") == path {
+			return &l.Paths[i]
+		}
+	}
+	if len(l.Strategy) > 0 {
+		return &l.ListMergeStrategy
+	}
+	return nil
+}
+
+// MergeLists combines every list src holds at a path with a strategy with the list dst holds there.
+// The combined list is set in dst and in the copy of src returned, so whatever merges src into dst next,
+// overwriting or not, keeps it. basePath is the path of dst and src within the values, src is not modified
+func (l *ListMerge) MergeLists(dst map[string]interface{}, src map[string]interface{}, basePath string, overwrite bool) map[string]interface{} {
+	if dst == nil || src == nil {
+		return src
+	}
+	merged := make(map[string]interface{}, len(src))
+	for key, srcValue := range src {
+		merged[key] = srcValue
+		path := joinPath(basePath, key)
+		switch srcTyped := srcValue.(type) {
+		case map[string]interface{}:
+			if dstTyped, ok := dst[key].(map[string]interface{}); ok {
+				merged[key] = l.MergeLists(dstTyped, srcTyped, path, overwrite)
+			}
+		case []interface{}:
+			dstTyped, ok := dst[key].([]interface{})
+			if !ok {
+				continue
+			}
+			if strategy := l.strategyFor(path); strategy != nil {
+				combined := l.combine(strategy, dstTyped, srcTyped, path, overwrite)
+				merged[key] = combined
+				dst[key] = combined
+			}
+		}
+	}
+	return merged
+}
+
+func (l *ListMerge) combine(strategy *ListMergeStrategy, dst []interface{}, src []interface{}, path string, overwrite bool) []interface{} {
+	combined := make([]interface{}, 0, len(dst)+len(src))
+	switch strategy.Strategy {
+	case ListMergeAppend:
+		combined = append(append(combined, dst...), src...)
+	case ListMergePrepend:
+		combined = append(append(combined, src...), dst...)
+	case ListMergeMergeByKey:
+		combined = append(combined, dst...)
+		for _, srcItem := range src {
+			index := indexByKey(combined, strategy.Key, srcItem)
+			if index < 0 {
+				combined = append(combined, srcItem)
+				continue
+			}
+			combined[index] = l.mergeItem(combined[index].(map[string]interface{}), srcItem.(map[string]interface{}), path, overwrite)
+		}
+	default:
+		combined = append(combined, src...)
+	}
+	return combined
+}
+
+// mergeItem merges two list items sharing a key, overwrite decides which of them a value set in both comes from
+func (l *ListMerge) mergeItem(dst map[string]interface{}, src map[string]interface{}, path string, overwrite bool) map[string]interface{} {
+	merged := make(map[string]interface{}, len(dst)+len(src))
+	for key, value := range dst {
+		merged[key] = value
+	}
+	src = l.MergeLists(merged, src, path, overwrite)
+	for key, srcValue := range src {
+		dstValue, ok := merged[key]
+		dstMap, dstIsMap := dstValue.(map[string]interface{})
+		srcMap, srcIsMap := srcValue.(map[string]interface{})
+		switch {
+		case !ok || dstValue == nil:
+			merged[key] = srcValue
+		case dstIsMap && srcIsMap:
+			merged[key] = l.mergeItem(dstMap, srcMap, joinPath(path, key), overwrite)
+		case overwrite:
+			merged[key] = srcValue
+		}
+	}
+	return merged
+}
+
+// indexByKey returns the index of the item in list with the same value at key as item, or -1.
+// Items that are not maps or lack the key never match
+func indexByKey(list []interface{}, key string, item interface{}) int {
+	itemMap, ok := item.(map[string]interface{})
+	if !ok {
+		return -1
+	}
+	value, ok := itemMap[key]
+	if !ok {
+		return -1
+	}
+	for i, candidate := range list {
+		if candidateMap, ok := candidate.(map[string]interface{}); ok {
+			if candidateValue, ok := candidateMap[key]; ok && reflect.DeepEqual(candidateValue, value) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func joinPath(basePath string, key string) string {
+	if len(basePath) == 0 {
+		return key
+	}
+	return basePath + "." + key
+}
@@ -0,0 +1,220 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/yaml"
+)
+
+func TestListMerge(t *testing.T) {
+	dstValues := `
+extraEnv:
+- name: A
+  value: a
+- name: B
+  value: b
+tolerations:
+- key: one
+engine:
+  args: [--x]
+  containers:
+  - name: engine
+    env:
+    - name: A
+    resources:
+      cpu: 1
+`
+	srcValues := `
+extraEnv:
+- name: B
+  value: b2
+- name: C
+  value: c
+tolerations:
+- key: two
+engine:
+  args: [--y]
+  containers:
+  - name: engine
+    env:
+    - name: B
+    resources:
+      memory: 1Gi
+      cpu: 2
+  - name: sidecar
+`
+	testCases := []struct {
+		name      string
+		listMerge string
+		overwrite bool
+		expected  string
+	}{
+		{
+			name:      "no_strategy",
+			listMerge: `{}`,
+			expected:  dstValues,
+		},
+		{
+			name:      "replace_everywhere",
+			listMerge: `strategy: replace`,
+			expected: `
+extraEnv:
+- name: B
+  value: b2
+- name: C
+  value: c
+tolerations:
+- key: two
+engine:
+  args: [--y]
+  containers:
+  - name: engine
+    env:
+    - name: B
+    resources:
+      memory: 1Gi
+      cpu: 2
+  - name: sidecar
+`,
+		},
+		{
+			name: "per_path",
+			listMerge: `
+paths:
+- path: extraEnv
+  strategy: mergeByKey
+  key: name
+- path: tolerations
+  strategy: append
+- path: engine.args
+  strategy: prepend
+`,
+			expected: `
+extraEnv:
+- name: A
+  value: a
+- name: B
+  value: b
+- name: C
+  value: c
+tolerations:
+- key: one
+- key: two
+engine:
+  args: [--y, --x]
+  containers:
+  - name: engine
+    env:
+    - name: A
+    resources:
+      cpu: 1
+`,
+		},
+		{
+			name:      "mergeByKey_overwrite_and_nested_path",
+			overwrite: true,
+			listMerge: `
+strategy: mergeByKey
+key: name
+paths:
+- path: engine.containers.env
+  strategy: append
+- path: tolerations
+  strategy: replace
+`,
+			expected: `
+extraEnv:
+- name: A
+  value: a
+- name: B
+  value: b2
+- name: C
+  value: c
+tolerations:
+- key: two
+engine:
+  args: [--x, --y]
+  containers:
+  - name: engine
+    env:
+    - name: A
+    - name: B
+    resources:
+      memory: 1Gi
+      cpu: 2
+  - name: sidecar
+`,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var listMerge ListMerge
+			var dst, src, expected map[string]interface{}
+			for data, target := range map[string]interface{}{
+				testCase.listMerge: &listMerge,
+				dstValues:          &dst,
+				srcValues:          &src,
+			} {
+				if err := yaml.Unmarshal([]byte(data), target); err != nil {
+					t.Fatalf("Err: %v", err)
+				}
+			}
+			if err := yaml.Unmarshal([]byte(testCase.expected), &expected); err != nil {
+				t.Fatalf("Err: %v", err)
+			}
+			assert.NoError(t, listMerge.Validate())
+
+			srcBefore, _ := yaml.Marshal(src)
+			merged := listMerge.MergeLists(dst, src, "", testCase.overwrite)
+			srcAfter, _ := yaml.Marshal(src)
+			assert.Equal(t, string(srcBefore), string(srcAfter))
+
+			// merging merged into dst keeps dst's lists unless it overwrites, either way the lists are combined
+			if testCase.overwrite {
+				assert.Equal(t, expected, merged)
+			} else {
+				assert.Equal(t, expected, dst)
+			}
+		})
+	}
+}
+
+func TestListMergeValidate(t *testing.T) {
+	testCases := []struct {
+		name      string
+		listMerge ListMerge
+		valid     bool
+	}{
+		{
+			name:  "empty",
+			valid: true,
+		},
+		{
+			name:      "unknown_strategy",
+			listMerge: ListMerge{ListMergeStrategy: ListMergeStrategy{Strategy: "zip"}},
+		},
+		{
+			name:      "mergeByKey_without_key",
+			listMerge: ListMerge{Paths: []ListMergeStrategy{{Path: "env", Strategy: ListMergeMergeByKey}}},
+		},
+		{
+			name:      "path_without_strategy",
+			listMerge: ListMerge{Paths: []ListMergeStrategy{{Path: "env"}}},
+		},
+		{
+			name:      "strategy_without_path",
+			listMerge: ListMerge{Paths: []ListMergeStrategy{{Strategy: ListMergeAppend}}},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := testCase.listMerge.Validate()
+			if testCase.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
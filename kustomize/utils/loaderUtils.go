@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"sigs.k8s.io/kustomize/v3/pkg/fs"
+	"sigs.k8s.io/kustomize/v3/pkg/ifc"
+	"sigs.k8s.io/kustomize/v3/pkg/loader"
+)
+
+// LoadFromLoader reads location through ldr, so that relative paths resolve against the kustomization root.
+// http(s) urls are fetched only when the loader's restrictor allows loading from outside the root
+func LoadFromLoader(ldr ifc.Loader, location string, logger *log.Logger) ([]byte, error) {
+	if u, err := url.Parse(location); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		if LoaderRestrictsToRoot(ldr) {
+			err := fmt.Errorf("loading: %v is not allowed, the load restrictor only permits files under: %v", location, ldr.Root())
+			logger.Printf("%v\n", err)
+			return nil, err
+		}
+		return HTTPGet(location, logger)
+	}
+	data, err := ldr.Load(location)
+	if err != nil {
+		logger.Printf("error loading: %v from: %v, error: %v\n", location, ldr.Root(), err)
+		return nil, err
+	}
+	return data, nil
+}
+
+// LoaderRestrictsToRoot reports whether ldr refuses to load from outside its root.
+// The loader api does not expose its restrictor, so this loads a file created outside the root
+// and compares the error with the one loader.RestrictionRootOnly itself gives for that file.
+// When no such file can be set up, the loader is taken to be restricted
+func LoaderRestrictsToRoot(ldr ifc.Loader) bool {
+	dir, err := ioutil.TempDir("", "load-restrictor")
+	if err != nil {
+		return true
+	}
+	defer os.RemoveAll(dir)
+	probe := filepath.Join(dir, "probe.yaml")
+	if err := ioutil.WriteFile(probe, []byte{}, 0600); err != nil {
+		return true
+	}
+	rootOnlyErr := restrictionRootOnlyError(ldr.Root(), probe)
+	if rootOnlyErr == nil {
+		// the probe is under the root, so it tells nothing about the restrictor
+		return true
+	}
+	_, err = ldr.Load(probe)
+	return err != nil && err.Error() == rootOnlyErr.Error()
+}
+
+// restrictionRootOnlyError is the error the root only restrictor gives for loading path from root
+func restrictionRootOnlyError(root, path string) error {
+	_, err := loader.RestrictionRootOnly(fs.MakeFsInMemory(), fs.ConfirmedDir(root), path)
+	return err
+}
+
+// globber is implemented by loaders that can list their file system, such as the fake loader
+type globber interface {
+	Glob(pattern string) ([]string, error)
+}
+
+// GlobFromLoader returns the paths matching pattern, relative patterns are relative to the kustomization root.
+// The loader api cannot list directories, so unless ldr can glob, pattern is matched on disk;
+// the matches are meant to be read through the loader all the same
+func GlobFromLoader(ldr ifc.Loader, pattern string, logger *log.Logger) ([]string, error) {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(ldr.Root(), pattern)
+	}
+	glob := filepath.Glob
+	if g, ok := ldr.(globber); ok {
+		glob = g.Glob
+	}
+	matches, err := glob(pattern)
+	if err != nil {
+		logger.Printf("error matching: %v, error: %v\n", pattern, err)
+		return nil, err
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/qlik-oss/kustomize-plugins/kustomize/utils/loadertest"
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/kustomize/v3/k8sdeps/validator"
+	"sigs.k8s.io/kustomize/v3/pkg/fs"
+	"sigs.k8s.io/kustomize/v3/pkg/loader"
+)
+
+func TestLoadFromLoader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("remote: true\n"))
+	}))
+	defer server.Close()
+
+	logger := GetLogger("LoaderUtilsTest")
+
+	rootOnly := loadertest.NewFakeLoader("/app/overlay")
+	if err := rootOnly.AddFile("/app/overlay/values.yaml", []byte("local: true\n")); err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+	assert.True(t, LoaderRestrictsToRoot(rootOnly))
+
+	data, err := LoadFromLoader(rootOnly, "values.yaml", logger)
+	assert.NoError(t, err)
+	assert.Equal(t, "local: true\n", string(data))
+
+	_, err = LoadFromLoader(rootOnly, server.URL+"/values.yaml", logger)
+	assert.Error(t, err)
+
+	unrestricted := loadertest.NewFakeLoaderWithRestrictor(loader.RestrictionNone, "/app/overlay")
+	if err := unrestricted.AddFile("/app/base/values.yaml", []byte("base: true\n")); err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+	assert.False(t, LoaderRestrictsToRoot(unrestricted))
+
+	data, err = LoadFromLoader(unrestricted, "../base/values.yaml", logger)
+	assert.NoError(t, err)
+	assert.Equal(t, "base: true\n", string(data))
+
+	data, err = LoadFromLoader(unrestricted, server.URL+"/values.yaml", logger)
+	assert.NoError(t, err)
+	assert.Equal(t, "remote: true\n", string(data))
+}
+
+// LoaderRestrictsToRoot relies on the root only restrictor refusing a directory, and on nothing else refusing it the same way
+func TestLoaderRestrictsToRoot(t *testing.T) {
+	assert.EqualError(t, restrictionRootOnlyError("/app/overlay", "/tmp/probe.yaml"),
+		"security; file '/tmp/probe.yaml' is not in or below '/app/overlay'")
+	assert.NoError(t, restrictionRootOnlyError("/", "/tmp/probe.yaml"))
+
+	assert.True(t, LoaderRestrictsToRoot(loadertest.NewFakeLoader("/app/overlay")))
+	assert.False(t, LoaderRestrictsToRoot(loadertest.NewFakeLoaderWithRestrictor(loader.RestrictionNone, "/app/overlay")))
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, testCase := range []struct {
+		name       string
+		restrictor loader.LoadRestrictorFunc
+		root       string
+		restricted bool
+	}{
+		{
+			name:       "root_only_on_disk",
+			restrictor: loader.RestrictionRootOnly,
+			root:       dir,
+			restricted: true,
+		},
+		{
+			name:       "none_on_disk",
+			restrictor: loader.RestrictionNone,
+			root:       dir,
+			restricted: false,
+		},
+		{
+			name:       "root_without_outside",
+			restrictor: loader.RestrictionNone,
+			root:       "/",
+			restricted: true,
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			ldr, err := loader.NewLoader(testCase.restrictor, validator.NewKustValidator(), testCase.root, fs.MakeFsOnDisk())
+			if err != nil {
+				t.Fatalf("Err: %v", err)
+			}
+			assert.Equal(t, testCase.restricted, LoaderRestrictsToRoot(ldr))
+		})
+	}
+}
+
+func TestGlobFromLoader(t *testing.T) {
+	logger := GetLogger("LoaderUtilsTest")
+
+	ldr := loadertest.NewFakeLoader("/app")
+	for _, file := range []string{"/app/values/b.yaml", "/app/values/a.yaml", "/app/values/c.txt", "/app/other.yaml"} {
+		if err := ldr.AddFile(file, []byte("{}")); err != nil {
+			t.Fatalf("Err: %v", err)
+		}
+	}
+
+	matches, err := GlobFromLoader(ldr, "values/*.yaml", logger)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/app/values/a.yaml", "/app/values/b.yaml"}, matches)
+
+	matches, err = GlobFromLoader(ldr, "/app/missing/*", logger)
+	assert.NoError(t, err)
+	assert.Empty(t, matches)
+}
@@ -87,3 +87,8 @@ func (f FakeLoader) Validator() ifc.Validator {
 func (f FakeLoader) LoadKvPairs(args types.GeneratorArgs) ([]types.Pair, error) {
 	return f.delegate.LoadKvPairs(args)
 }
+
+// Glob delegates to the fake file system.
+func (f FakeLoader) Glob(pattern string) ([]string, error) {
+	return f.fs.Glob(pattern)
+}
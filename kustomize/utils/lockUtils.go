@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// LockFile blocks until it holds an exclusive advisory lock on path (created if missing),
+// the returned func releases the lock
+func LockFile(path string, logger *log.Logger) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		logger.Printf("error creating directory for lock file: %v, error: %v\n", path, err)
+		return nil, err
+	}
+	lockFile, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		logger.Printf("error opening lock file: %v, error: %v\n", path, err)
+		return nil, err
+	}
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		lockFile.Close()
+		logger.Printf("error locking file: %v, error: %v\n", path, err)
+		return nil, err
+	}
+	return func() {
+		if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN); err != nil {
+			logger.Printf("error unlocking file: %v, error: %v\n", path, err)
+		}
+		lockFile.Close()
+	}, nil
+}
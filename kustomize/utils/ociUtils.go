@@ -0,0 +1,249 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	HelmChartContentMediaType       = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+	legacyHelmChartContentMediaType = "application/tar+gzip"
+	ociManifestMediaType            = "application/vnd.oci.image.manifest.v1+json"
+)
+
+var challengeParamRegexp = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// OCIClient pulls helm charts stored as OCI artifacts from a registry, using the distribution (v2) http api
+type OCIClient struct {
+	httpClient *http.Client
+	options    *HTTPOptions
+	logger     *log.Logger
+}
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+type ociManifest struct {
+	Layers []ociDescriptor `json:"layers"`
+}
+
+func NewOCIClient(logger *log.Logger) *OCIClient {
+	return &OCIClient{httpClient: http.DefaultClient, logger: logger}
+}
+
+// NewOCIClientWithOptions returns a client using the credentials and tls settings in options,
+// registries configured with tls settings are always spoken to over https
+func NewOCIClientWithOptions(options *HTTPOptions, logger *log.Logger) (*OCIClient, error) {
+	httpClient, err := options.Client()
+	if err != nil {
+		logger.Printf("error creating http client for registry, error: %v\n", err)
+		return nil, err
+	}
+	return &OCIClient{httpClient: httpClient, options: options, logger: logger}, nil
+}
+
+// Tags lists the tags of the repository ref, for example oci://registry.example.com/charts/mychart
+func (c *OCIClient) Tags(ref string) ([]string, error) {
+	host, repository, err := parseOCIReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	body, err := c.get(host, repository, "/tags/list", "")
+	if err != nil {
+		c.logger.Printf("error listing tags of: %v, error: %v\n", ref, err)
+		return nil, err
+	}
+	var tagList struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.Unmarshal(body, &tagList); err != nil {
+		c.logger.Printf("error unmarshalling tag list of: %v, error: %v\n", ref, err)
+		return nil, err
+	}
+	return tagList.Tags, nil
+}
+
+// ChartDigest returns the sha256 digest (without the algorithm prefix) of the chart layer tagged tag in ref
+func (c *OCIClient) ChartDigest(ref string, tag string) (string, error) {
+	host, repository, err := parseOCIReference(ref)
+	if err != nil {
+		return "", err
+	}
+	body, err := c.get(host, repository, "/manifests/"+tag, ociManifestMediaType)
+	if err != nil {
+		c.logger.Printf("error fetching manifest of: %v:%v, error: %v\n", ref, tag, err)
+		return "", err
+	}
+	var manifest ociManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		c.logger.Printf("error unmarshalling manifest of: %v:%v, error: %v\n", ref, tag, err)
+		return "", err
+	}
+	for _, layer := range manifest.Layers {
+		if layer.MediaType == HelmChartContentMediaType || layer.MediaType == legacyHelmChartContentMediaType {
+			if !strings.HasPrefix(layer.Digest, "sha256:") {
+				return "", fmt.Errorf("chart layer of: %v:%v has an unsupported digest: %v", ref, tag, layer.Digest)
+			}
+			return strings.TrimPrefix(layer.Digest, "sha256:"), nil
+		}
+	}
+	err = fmt.Errorf("manifest of: %v:%v has no helm chart layer", ref, tag)
+	c.logger.Printf("%v\n", err)
+	return "", err
+}
+
+// PullChart returns the chart archive with the given sha256 digest from ref, verifying its content
+func (c *OCIClient) PullChart(ref string, digest string) ([]byte, error) {
+	host, repository, err := parseOCIReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := c.get(host, repository, "/blobs/sha256:"+digest, "")
+	if err != nil {
+		c.logger.Printf("error fetching blob: %v of: %v, error: %v\n", digest, ref, err)
+		return nil, err
+	}
+	if actual := Digest(archive); actual != digest {
+		err := fmt.Errorf("blob of: %v has digest: %v but: %v was requested", ref, actual, digest)
+		c.logger.Printf("%v\n", err)
+		return nil, err
+	}
+	return archive, nil
+}
+
+// parseOCIReference splits oci://host/path into the registry host and the repository path
+func parseOCIReference(ref string) (string, string, error) {
+	trimmed := strings.Trim(strings.TrimPrefix(ref, "oci://"), "/")
+	slash := strings.Index(trimmed, "/")
+	if slash <= 0 {
+		return "", "", fmt.Errorf("invalid oci reference: %v, expected oci://<registry>/<repository>", ref)
+	}
+	return trimmed[:slash], trimmed[slash+1:], nil
+}
+
+// registries on the loopback interface are spoken to over plain http, the same exception docker makes
+func registryScheme(host string) string {
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	if hostname == "localhost" {
+		return "http"
+	}
+	if ip := net.ParseIP(hostname); ip != nil && ip.IsLoopback() {
+		return "http"
+	}
+	return "https"
+}
+
+func (c *OCIClient) get(host string, repository string, path string, accept string) ([]byte, error) {
+	scheme := registryScheme(host)
+	if c.options.HasTLS() {
+		scheme = "https"
+	}
+	requestURL := fmt.Sprintf("%s://%s/v2/%s%s", scheme, host, repository, path)
+
+	authorization := ""
+	if c.options != nil && len(c.options.Token) > 0 {
+		authorization = "Bearer " + c.options.Token
+	}
+	response, err := c.do(requestURL, accept, authorization)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode == http.StatusUnauthorized && len(authorization) == 0 {
+		challenge := response.Header.Get("WWW-Authenticate")
+		response.Body.Close()
+		if strings.HasPrefix(challenge, "Basic ") && c.options != nil {
+			authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(c.options.Username+":"+c.options.Password))
+		} else {
+			token, err := c.token(challenge)
+			if err != nil {
+				return nil, err
+			}
+			authorization = "Bearer " + token
+		}
+		response, err = c.do(requestURL, accept, authorization)
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		return nil, fmt.Errorf("failed to fetch %v : %v", requestURL, response.Status)
+	}
+	return ioutil.ReadAll(response.Body)
+}
+
+func (c *OCIClient) do(requestURL string, accept string, authorization string) (*http.Response, error) {
+	request, err := http.NewRequest(http.MethodGet, requestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(accept) > 0 {
+		request.Header.Set("Accept", accept)
+	}
+	if len(authorization) > 0 {
+		request.Header.Set("Authorization", authorization)
+	}
+	return c.httpClient.Do(request)
+}
+
+// token answers a Bearer challenge from the registry with a token from its auth realm,
+// anonymous unless there are basic auth credentials to log in with
+func (c *OCIClient) token(challenge string) (string, error) {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return "", fmt.Errorf("unsupported registry auth challenge: %v", challenge)
+	}
+	params := map[string]string{}
+	for _, match := range challengeParamRegexp.FindAllStringSubmatch(challenge, -1) {
+		params[match[1]] = match[2]
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || len(params["realm"]) == 0 {
+		return "", fmt.Errorf("registry auth challenge has no usable realm: %v", challenge)
+	}
+	query := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if len(params[key]) > 0 {
+			query.Set(key, params[key])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	authorization := ""
+	if c.options != nil && (len(c.options.Username) > 0 || len(c.options.Password) > 0) {
+		authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(c.options.Username+":"+c.options.Password))
+	}
+	response, err := c.do(realm.String(), "", authorization)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch registry token from %v : %v", realm.String(), response.Status)
+	}
+	var tokenResponse struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&tokenResponse); err != nil {
+		return "", err
+	}
+	if len(tokenResponse.Token) > 0 {
+		return tokenResponse.Token, nil
+	}
+	return tokenResponse.AccessToken, nil
+}
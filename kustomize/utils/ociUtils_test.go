@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/qlik-oss/kustomize-plugins/kustomize/utils/registrytest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOCIClient(t *testing.T) {
+	registry := registrytest.NewFakeRegistry()
+	defer registry.Close()
+
+	registry.Push("charts/foo", "1.0.0", []byte("foo-1.0.0"))
+	registry.Push("charts/foo", "1.1.0", []byte("foo-1.1.0"))
+
+	client := NewOCIClient(GetLogger("OCIClientTest"))
+	ref := "oci://" + registry.Host() + "/charts/foo"
+
+	tags, err := client.Tags(ref)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.0.0", "1.1.0"}, tags)
+
+	digest, err := client.ChartDigest(ref, "1.1.0")
+	assert.NoError(t, err)
+	assert.Equal(t, Digest([]byte("foo-1.1.0")), digest)
+
+	archive, err := client.PullChart(ref, digest)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("foo-1.1.0"), archive)
+
+	_, err = client.ChartDigest(ref, "2.0.0")
+	assert.Error(t, err)
+
+	_, err = client.PullChart(ref, Digest([]byte("missing")))
+	assert.Error(t, err)
+
+	_, err = client.Tags("oci://" + registry.Host())
+	assert.Error(t, err)
+}
+
+func TestOCIClientWithOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oci-utils-test")
+	if err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	registry := registrytest.NewFakeTLSRegistry()
+	defer registry.Close()
+	registry.Username = "user"
+	registry.Password = "pass"
+	registry.Push("charts/foo", "1.0.0", []byte("foo-1.0.0"))
+	ref := "oci://" + registry.Host() + "/charts/foo"
+	caFile := writeServerCA(t, dir, registry.Server)
+	logger := GetLogger("OCIClientTest")
+
+	_, err = NewOCIClient(logger).Tags(ref)
+	assert.Error(t, err)
+
+	client, err := NewOCIClientWithOptions(&HTTPOptions{CAFile: caFile}, logger)
+	assert.NoError(t, err)
+	_, err = client.Tags(ref)
+	assert.Error(t, err)
+
+	client, err = NewOCIClientWithOptions(&HTTPOptions{CAFile: caFile, Username: "user", Password: "pass"}, logger)
+	assert.NoError(t, err)
+	tags, err := client.Tags(ref)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.0.0"}, tags)
+
+	digest, err := client.ChartDigest(ref, "1.0.0")
+	assert.NoError(t, err)
+	archive, err := client.PullChart(ref, digest)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("foo-1.0.0"), archive)
+}
+
+func TestRegistryScheme(t *testing.T) {
+	assert.Equal(t, "http", registryScheme("localhost:5000"))
+	assert.Equal(t, "http", registryScheme("127.0.0.1:5000"))
+	assert.Equal(t, "https", registryScheme("registry.example.com"))
+	assert.Equal(t, "https", registryScheme("registry.example.com:443"))
+}
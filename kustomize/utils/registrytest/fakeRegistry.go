@@ -0,0 +1,129 @@
+// Package registrytest holds a fake OCI registry for helm charts.
+package registrytest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+const chartLayerMediaType = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+
+// FakeRegistry serves pushed chart archives over the distribution (v2) http api,
+// requiring an anonymous bearer token the way public registries do.
+type FakeRegistry struct {
+	*httptest.Server
+	mu        sync.Mutex
+	manifests map[string][]byte
+	blobs     map[string][]byte
+	tags      map[string][]string
+	Requests  int
+	// Username and Password, when set, are required as basic auth to get a token
+	Username string
+	Password string
+}
+
+const fakeToken = "fake-registry-token"
+
+// NewFakeRegistry starts a fake registry on the loopback interface, Close it when done.
+func NewFakeRegistry() *FakeRegistry {
+	r := &FakeRegistry{
+		manifests: map[string][]byte{},
+		blobs:     map[string][]byte{},
+		tags:      map[string][]string{},
+	}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serve))
+	return r
+}
+
+// NewFakeTLSRegistry starts a fake registry serving https with a self signed certificate, Close it when done.
+func NewFakeTLSRegistry() *FakeRegistry {
+	r := &FakeRegistry{
+		manifests: map[string][]byte{},
+		blobs:     map[string][]byte{},
+		tags:      map[string][]string{},
+	}
+	r.Server = httptest.NewTLSServer(http.HandlerFunc(r.serve))
+	return r
+}
+
+// Host returns the host:port of the registry.
+func (r *FakeRegistry) Host() string {
+	return strings.TrimPrefix(strings.TrimPrefix(r.URL, "http://"), "https://")
+}
+
+// Push stores archive as the chart layer of repository:tag.
+func (r *FakeRegistry) Push(repository string, tag string, archive []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sum := sha256.Sum256(archive)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	r.blobs[digest] = archive
+	manifest, _ := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"config": map[string]interface{}{
+			"mediaType": "application/vnd.cncf.helm.config.v1+json",
+			"digest":    digest,
+			"size":      len(archive),
+		},
+		"layers": []map[string]interface{}{{
+			"mediaType": chartLayerMediaType,
+			"digest":    digest,
+			"size":      len(archive),
+		}},
+	})
+	r.manifests[repository+":"+tag] = manifest
+	r.tags[repository] = append(r.tags[repository], tag)
+}
+
+func (r *FakeRegistry) serve(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Requests++
+
+	if req.URL.Path == "/token" {
+		if username, password, _ := req.BasicAuth(); username != r.Username || password != r.Password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"token": fakeToken})
+		return
+	}
+	if req.Header.Get("Authorization") != "Bearer "+fakeToken {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake",scope="pull"`, r.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	switch {
+	case strings.HasSuffix(path, "/tags/list"):
+		repository := strings.TrimSuffix(path, "/tags/list")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"name": repository, "tags": r.tags[repository]})
+	case strings.Contains(path, "/manifests/"):
+		parts := strings.SplitN(path, "/manifests/", 2)
+		manifest, ok := r.manifests[parts[0]+":"+parts[1]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+		_, _ = w.Write(manifest)
+	case strings.Contains(path, "/blobs/"):
+		parts := strings.SplitN(path, "/blobs/", 2)
+		blob, ok := r.blobs[parts[1]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(blob)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
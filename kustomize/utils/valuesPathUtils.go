@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// valuesPathElement is a map key, or a list index when key is empty
+type valuesPathElement struct {
+	key   string
+	index int
+}
+
+// parseValuesPath splits a path written the way helm's --set writes them, such as ingress.hosts[0].host,
+// into its keys and list indexes. A backslash escapes the character after it, so a\.b is the single key a.b
+func parseValuesPath(path string) ([]valuesPathElement, error) {
+	var elements []valuesPathElement
+	var key strings.Builder
+	keyDone := false
+	runes := []rune(path)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; r {
+		case '\\':
+			if i+1 == len(runes) {
+				return nil, fmt.Errorf("values path: %v ends in an escape", path)
+			}
+			i++
+			key.WriteRune(runes[i])
+		case '.':
+			if key.Len() == 0 && !keyDone {
+				return nil, fmt.Errorf("values path: %v has an empty key", path)
+			}
+			if key.Len() > 0 {
+				elements = append(elements, valuesPathElement{key: key.String()})
+				key.Reset()
+			}
+			keyDone = false
+		case '[':
+			if key.Len() == 0 && !keyDone {
+				return nil, fmt.Errorf("values path: %v has an index without a key", path)
+			}
+			end := i + 1
+			for end < len(runes) && runes[end] != ']' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("values path: %v has an unterminated index", path)
+			}
+			indexString := string(runes[i+1 : end])
+			index, err := strconv.Atoi(indexString)
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("values path: %v has an invalid index: %v", path, indexString)
+			}
+			if key.Len() > 0 {
+				elements = append(elements, valuesPathElement{key: key.String()})
+				key.Reset()
+			}
+			elements = append(elements, valuesPathElement{index: index})
+			keyDone = true
+			i = end
+		default:
+			if keyDone {
+				return nil, fmt.Errorf("values path: %v has no '.' after an index", path)
+			}
+			key.WriteRune(r)
+		}
+	}
+	if key.Len() > 0 {
+		elements = append(elements, valuesPathElement{key: key.String()})
+	} else if !keyDone {
+		return nil, fmt.Errorf("values path: %v has an empty key", path)
+	}
+	return elements, nil
+}
+
+// SetValue sets a copy of value at path in values, creating the maps and growing the lists on the way
+// the way helm's --set does: whatever is in the way is replaced
+func SetValue(values map[string]interface{}, path string, value interface{}) error {
+	elements, err := parseValuesPath(path)
+	if err != nil {
+		return err
+	}
+	values[elements[0].key] = setValue(values[elements[0].key], elements[1:], copyValue(value))
+	return nil
+}
+
+func setValue(current interface{}, elements []valuesPathElement, value interface{}) interface{} {
+	if len(elements) == 0 {
+		return value
+	}
+	element := elements[0]
+	if len(element.key) > 0 {
+		currentMap, ok := current.(map[string]interface{})
+		if !ok {
+			currentMap = map[string]interface{}{}
+		}
+		currentMap[element.key] = setValue(currentMap[element.key], elements[1:], value)
+		return currentMap
+	}
+	currentList, _ := current.([]interface{})
+	for len(currentList) <= element.index {
+		currentList = append(currentList, nil)
+	}
+	currentList[element.index] = setValue(currentList[element.index], elements[1:], value)
+	return currentList
+}
+
+// UnsetValue removes the key or list item at path from values, a path that is not there is left alone
+func UnsetValue(values map[string]interface{}, path string) error {
+	elements, err := parseValuesPath(path)
+	if err != nil {
+		return err
+	}
+	if len(elements) == 1 {
+		delete(values, elements[0].key)
+		return nil
+	}
+	if current, ok := values[elements[0].key]; ok {
+		values[elements[0].key] = unsetValue(current, elements[1:])
+	}
+	return nil
+}
+
+func unsetValue(current interface{}, elements []valuesPathElement) interface{} {
+	element := elements[0]
+	if len(element.key) > 0 {
+		currentMap, ok := current.(map[string]interface{})
+		if !ok {
+			return current
+		}
+		if len(elements) == 1 {
+			delete(currentMap, element.key)
+		} else if next, ok := currentMap[element.key]; ok {
+			currentMap[element.key] = unsetValue(next, elements[1:])
+		}
+		return currentMap
+	}
+	currentList, ok := current.([]interface{})
+	if !ok || element.index >= len(currentList) {
+		return current
+	}
+	if len(elements) == 1 {
+		return append(currentList[:element.index:element.index], currentList[element.index+1:]...)
+	}
+	currentList[element.index] = unsetValue(currentList[element.index], elements[1:])
+	return currentList
+}
+
+// copyValue deep copies the maps and lists of value so that setting it never shares them
+func copyValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(typed))
+		for key, item := range typed {
+			copied[key] = copyValue(item)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(typed))
+		for i, item := range typed {
+			copied[i] = copyValue(item)
+		}
+		return copied
+	default:
+		return value
+	}
+}
@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/yaml"
+)
+
+func TestParseValuesPath(t *testing.T) {
+	testCases := []struct {
+		path     string
+		expected []valuesPathElement
+		invalid  bool
+	}{
+		{path: "image.tag", expected: []valuesPathElement{{key: "image"}, {key: "tag"}}},
+		{path: "ingress.hosts[0].host", expected: []valuesPathElement{{key: "ingress"}, {key: "hosts"}, {index: 0}, {key: "host"}}},
+		{path: "matrix[1][2]", expected: []valuesPathElement{{key: "matrix"}, {index: 1}, {index: 2}}},
+		{path: `annotations.qlik\.com/tier`, expected: []valuesPathElement{{key: "annotations"}, {key: "qlik.com/tier"}}},
+		{path: `a\[0\]`, expected: []valuesPathElement{{key: "a[0]"}}},
+		{path: "", invalid: true},
+		{path: "a..b", invalid: true},
+		{path: "a.", invalid: true},
+		{path: "[0]", invalid: true},
+		{path: "a[x]", invalid: true},
+		{path: "a[-1]", invalid: true},
+		{path: "a[0", invalid: true},
+		{path: "a[0]b", invalid: true},
+		{path: `a\`, invalid: true},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.path, func(t *testing.T) {
+			elements, err := parseValuesPath(testCase.path)
+			if testCase.invalid {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expected, elements)
+		})
+	}
+}
+
+func TestSetAndUnsetValue(t *testing.T) {
+	var values map[string]interface{}
+	if err := yaml.Unmarshal([]byte(`
+image:
+  repository: engine
+  tag: 1.0.0
+ingress:
+  hosts:
+  - host: a
+    paths: [/]
+  - host: b
+scalar: 1
+`), &values); err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+
+	shared := map[string]interface{}{"enabled": true}
+	for path, value := range map[string]interface{}{
+		"image.tag":             "1.2.3",
+		"ingress.hosts[0].host": "x",
+		"ingress.hosts[3].host": "d",
+		"scalar.nested":         "replaced",
+		`labels.qlik\.com/tier`: "backend",
+		"metrics":               shared,
+	} {
+		assert.NoError(t, SetValue(values, path, value))
+	}
+	shared["enabled"] = false
+
+	for _, path := range []string{"image.repository", "ingress.hosts[1]", "ingress.hosts[0].paths", "missing.key", "ingress.hosts[9]"} {
+		assert.NoError(t, UnsetValue(values, path))
+	}
+	assert.Error(t, UnsetValue(values, "a..b"))
+	assert.Error(t, SetValue(values, "a..b", 1))
+
+	var expected map[string]interface{}
+	if err := yaml.Unmarshal([]byte(`
+image:
+  tag: 1.2.3
+ingress:
+  hosts:
+  - host: x
+  - null
+  - host: d
+scalar:
+  nested: replaced
+labels:
+  qlik.com/tier: backend
+metrics:
+  enabled: true
+`), &expected); err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+	assert.Equal(t, expected, values)
+}
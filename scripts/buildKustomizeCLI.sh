@@ -6,4 +6,11 @@ kustomizeVersionFlag="-X \"sigs.k8s.io/kustomize/kustomize/v3/provenance.version
 gitCommitFlag="-X sigs.k8s.io/kustomize/kustomize/v3/provenance.gitCommit=unknown"
 dateFlag="-X sigs.k8s.io/kustomize/kustomize/v3/provenance.buildDate=`date -u +'%Y-%m-%dT%H:%M:%SZ'`"
 
-GO111MODULE=on go get -ldflags "${kustomizeVersionFlag} ${gitCommitFlag} ${dateFlag}" sigs.k8s.io/kustomize/kustomize/v3@${versionOfKustomizeCLI}
+# go plugins only load when every package they share with the CLI is the same version, so the CLI is built
+# with the dependencies of the helmchart plugin, the Helm 3 SDK needs newer k8s.io modules than kustomize asks for
+cd "$(pwd)/kustomize/plugin/qlik.com/v1/helmchart"
+cp go.mod go.mod.orig && cp go.sum go.sum.orig
+trap 'mv go.mod.orig go.mod && mv go.sum.orig go.sum' EXIT
+
+GO111MODULE=on go get -d sigs.k8s.io/kustomize/kustomize/v3@${versionOfKustomizeCLI}
+GO111MODULE=on go build -ldflags "${kustomizeVersionFlag} ${gitCommitFlag} ${dateFlag}" -o "$(go env GOPATH)/bin/kustomize" sigs.k8s.io/kustomize/kustomize/v3